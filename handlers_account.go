@@ -0,0 +1,192 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "time"
+    "fmt"
+    "io"
+    "encoding/json"
+    "archive/zip"
+
+    "github.com/google/uuid"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+)
+
+// Permanently delete the authenticated user along with their chirps, likes, follows and sessions. The
+// password is required again so a stolen access token isn't enough to destroy an account.
+func (cfg *ApiConfig) HandleDeleteUser(res http.ResponseWriter, req *http.Request) {
+    type RequestParameters struct {
+        Password string `json:"password"`
+    }
+    var reqParams RequestParameters
+    if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+
+    userId := GetAuthenticatedUserId(req)
+
+    user, err := cfg.Db.GetUser(req.Context(), userId)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "user not found")
+        return
+    }
+    if !cfg.checkReenteredPassword(res, req, user, reqParams.Password, "incorrect password") { return }
+
+    if _, err := cfg.Db.DeleteUser(req.Context(), user.ID); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to delete user")
+        fmt.Printf("Failed to delete user %v: %v\n", user.ID, err)
+        return
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
+
+// Every refresh token the user was ever given. Tokens are credentials so only their metadata is exported.
+type ResponseExportSession struct {
+    SessionID   uuid.UUID   `json:"session_id"`
+    CreatedAt   time.Time   `json:"created_at"`
+    ExpiresAt   time.Time   `json:"expires_at"`
+    RevokedAt   *time.Time  `json:"revoked_at"`
+    UserAgent   string      `json:"user_agent"`
+    Ip          string      `json:"ip"`
+}
+
+// Part of a data export, loaded only when it's about to be written so the whole export never has to be
+// held in memory at once
+type userExportSection struct {
+    name string
+    load func(ctx context.Context) (any, error)
+}
+
+func (cfg *ApiConfig) getUserExportSections(user database.User) []userExportSection {
+    return []userExportSection {
+        {
+            name: "profile",
+            load: func(ctx context.Context) (any, error) { return MakeResponseUser(user), nil },
+        },
+        {
+            name: "chirps",
+            load: func(ctx context.Context) (any, error) {
+                // No limit, every chirp the user ever posted
+                params := database.ListChirpsParams { AuthorID: uuid.NullUUID { UUID: user.ID, Valid: true } }
+                chirps, err := cfg.Db.ListChirps(ctx, params)
+                if err != nil { return nil, err }
+                responseChirps := MakeResponseChirps(chirps)
+                author := MakeResponsePublicUser(user)
+                for i := range responseChirps { responseChirps[i].Author = &author }
+                return responseChirps, nil
+            },
+        },
+        {
+            name: "sessions",
+            load: func(ctx context.Context) (any, error) {
+                refreshTokens, err := cfg.Db.GetUserRefreshTokens(ctx, user.ID)
+                if err != nil { return nil, err }
+                sessions := make([]ResponseExportSession, 0, len(refreshTokens))
+                for _, refreshToken := range refreshTokens {
+                    session := ResponseExportSession {
+                        SessionID: refreshToken.FamilyID,
+                        CreatedAt: refreshToken.CreatedAt,
+                        ExpiresAt: refreshToken.ExpiresAt,
+                        UserAgent: refreshToken.UserAgent,
+                        Ip: refreshToken.Ip,
+                    }
+                    if refreshToken.RevokedAt.Valid { session.RevokedAt = &refreshToken.RevokedAt.Time }
+                    sessions = append(sessions, session)
+                }
+                return sessions, nil
+            },
+        },
+        {
+            name: "api_keys",
+            load: func(ctx context.Context) (any, error) {
+                apiKeys, err := cfg.Db.GetUserApiKeys(ctx, user.ID)
+                if err != nil { return nil, err }
+                responseApiKeys := make([]ResponseApiKey, 0, len(apiKeys))
+                for _, apiKey := range apiKeys { responseApiKeys = append(responseApiKeys, MakeResponseApiKey(apiKey)) }
+                return responseApiKeys, nil
+            },
+        },
+    }
+}
+
+// The authenticated user's profile, chirps, sessions and API keys. Their likes, follows, edit history and
+// notifications aren't included. Sent as a single json document by default, or as a zip archive with a
+// json file per section with ?format=zip.
+func (cfg *ApiConfig) HandleExportUser(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    format := req.URL.Query().Get("format")
+    if format == "" { format = "json" }
+    if format != "json" && format != "zip" {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "format must be either json or zip")
+        return
+    }
+
+    user, err := cfg.Db.GetUser(req.Context(), userId)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to export user data")
+        fmt.Printf("Failed to export data of user %v: %v\n", userId, err)
+        return
+    }
+    exportedAt := time.Now().UTC()
+    sections := cfg.getUserExportSections(user)
+
+    filename := fmt.Sprintf("chirpy-export-%v.%v", exportedAt.Format("2006-01-02"), format)
+    res.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, filename))
+
+    // Headers are already sent once the body starts streaming, so failures from here on can only be logged.
+    // The export is cut off where it failed, which leaves the json or zip invalid rather than incomplete
+    // without anyone noticing.
+    if format == "json" {
+        res.Header().Set("Content-Type", "application/json")
+        res.WriteHeader(http.StatusOK)
+        err = writeJsonExport(req.Context(), res, exportedAt, sections)
+    } else {
+        res.Header().Set("Content-Type", "application/zip")
+        res.WriteHeader(http.StatusOK)
+        err = writeZipExport(req.Context(), res, exportedAt, sections)
+    }
+    if err != nil { fmt.Printf("Failed to write data export of user %v: %v\n", userId, err) }
+}
+
+// One json object with exported_at followed by a field per section
+func writeJsonExport(ctx context.Context, w io.Writer, exportedAt time.Time, sections []userExportSection) error {
+    separator := "{"
+    writeField := func(name string, value any) error {
+        key, err := json.Marshal(name)
+        if err != nil { return err }
+        data, err := json.MarshalIndent(value, "  ", "  ")
+        if err != nil { return err }
+        if _, err := fmt.Fprintf(w, "%s\n  %s: %s", separator, key, data); err != nil { return err }
+        separator = ","
+        return nil
+    }
+
+    if err := writeField("exported_at", exportedAt); err != nil { return err }
+    for _, section := range sections {
+        data, err := section.load(ctx)
+        if err != nil { return err }
+        if err := writeField(section.name, data); err != nil { return err }
+    }
+    _, err := fmt.Fprint(w, "\n}\n")
+    return err
+}
+
+func writeZipExport(ctx context.Context, w io.Writer, exportedAt time.Time, sections []userExportSection) error {
+    archive := zip.NewWriter(w)
+    for _, section := range sections {
+        data, err := section.load(ctx)
+        if err != nil { return err }
+        header := zip.FileHeader { Name: section.name + ".json", Method: zip.Deflate, Modified: exportedAt }
+        file, err := archive.CreateHeader(&header)
+        if err != nil { return err }
+        encoder := json.NewEncoder(file)
+        encoder.SetIndent("", "  ")
+        if err := encoder.Encode(data); err != nil { return err }
+    }
+    return archive.Close()
+}
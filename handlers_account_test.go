@@ -0,0 +1,73 @@
+package main
+
+import (
+    "context"
+    "testing"
+    "bytes"
+    "errors"
+    "io"
+    "time"
+    "encoding/json"
+    "archive/zip"
+)
+
+func testExportSections(failing string) []userExportSection {
+    makeLoad := func(name string, data any) func(ctx context.Context) (any, error) {
+        return func(ctx context.Context) (any, error) {
+            if name == failing { return nil, errors.New("load failed") }
+            return data, nil
+        }
+    }
+    return []userExportSection {
+        { name: "profile", load: makeLoad("profile", map[string]string { "email": "a@b.c" }) },
+        { name: "chirps", load: makeLoad("chirps", []string { "first", "second" }) },
+        { name: "empty", load: makeLoad("empty", []string {}) },
+    }
+}
+
+func TestWriteJsonExport(t *testing.T) {
+    exportedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+    var buffer bytes.Buffer
+    if err := writeJsonExport(context.Background(), &buffer, exportedAt, testExportSections("")); err != nil {
+        t.Fatalf("Writing the export failed but shouldn't have: %v\n", err)
+    }
+    var export struct {
+        ExportedAt time.Time `json:"exported_at"`
+        Profile map[string]string `json:"profile"`
+        Chirps []string `json:"chirps"`
+        Empty []string `json:"empty"`
+    }
+    if err := json.Unmarshal(buffer.Bytes(), &export); err != nil {
+        t.Fatalf("Export isn't valid json: %v\n%s\n", err, buffer.String())
+    }
+    if !export.ExportedAt.Equal(exportedAt) || export.Profile["email"] != "a@b.c" || len(export.Chirps) != 2 || export.Empty == nil {
+        t.Errorf("Export doesn't contain the sections it was given: %s\n", buffer.String())
+    }
+
+    buffer.Reset()
+    if err := writeJsonExport(context.Background(), &buffer, exportedAt, testExportSections("chirps")); err == nil {
+        t.Error("Writing the export succeeded even though a section failed to load")
+    }
+    if json.Valid(buffer.Bytes()) { t.Error("Export that failed partway through is still valid json") }
+}
+
+func TestWriteZipExport(t *testing.T) {
+    var buffer bytes.Buffer
+    if err := writeZipExport(context.Background(), &buffer, time.Now(), testExportSections("")); err != nil {
+        t.Fatalf("Writing the export failed but shouldn't have: %v\n", err)
+    }
+    archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+    if err != nil { t.Fatalf("Export isn't a valid zip archive: %v\n", err) }
+
+    expectedNames := []string { "profile.json", "chirps.json", "empty.json" }
+    if len(archive.File) != len(expectedNames) { t.Fatalf("Expected %v files, got %v\n", len(expectedNames), len(archive.File)) }
+    for i, file := range archive.File {
+        if file.Name != expectedNames[i] { t.Errorf("Expected file %v to be %v, got %v\n", i, expectedNames[i], file.Name) }
+        reader, err := file.Open()
+        if err != nil { t.Fatalf("Failed to open %v: %v\n", file.Name, err) }
+        data, err := io.ReadAll(reader)
+        reader.Close()
+        if err != nil || !json.Valid(data) { t.Errorf("%v isn't valid json: %v\n", file.Name, err) }
+    }
+}
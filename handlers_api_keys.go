@@ -0,0 +1,137 @@
+package main
+
+import (
+    "net/http"
+    "time"
+    "fmt"
+    "slices"
+    "strings"
+
+    "github.com/google/uuid"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/auth"
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+)
+
+const MAX_API_KEY_NAME_LEN int = 50
+
+type ResponseApiKey struct {
+    ID          uuid.UUID   `json:"id"`
+    CreatedAt   time.Time   `json:"created_at"`
+    Name        string      `json:"name"`
+    Prefix      string      `json:"prefix"`
+    Scopes      []string    `json:"scopes"`
+    LastUsedAt  *time.Time  `json:"last_used_at"`
+    // Only sent when the key is created, it can't be retrieved again after that
+    Key         string      `json:"key,omitempty"`
+}
+
+func MakeResponseApiKey(apiKey database.ApiKey) ResponseApiKey {
+    result := ResponseApiKey {
+        ID: apiKey.ID,
+        CreatedAt: apiKey.CreatedAt,
+        Name: apiKey.Name,
+        Prefix: apiKey.KeyPrefix,
+        Scopes: apiKey.Scopes,
+    }
+    if apiKey.LastUsedAt.Valid { result.LastUsedAt = &apiKey.LastUsedAt.Time }
+    return result
+}
+
+// Create a key that acts on behalf of the authenticated user with only the requested scopes. It's sent with
+// the ApiKey authorization scheme instead of Bearer.
+func (cfg *ApiConfig) HandleCreateApiKey(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    type RequestParameters struct {
+        Name string `json:"name"`
+        Scopes []string `json:"scopes"`
+    }
+    var reqParams RequestParameters
+    if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+
+    name := strings.TrimSpace(reqParams.Name)
+    if name == "" || len([]rune(name)) > MAX_API_KEY_NAME_LEN {
+        SendJsonErrorResponse(res, http.StatusBadRequest, fmt.Sprintf("name must be 1 to %v characters long", MAX_API_KEY_NAME_LEN))
+        return
+    }
+    if len(reqParams.Scopes) == 0 {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "at least one scope is required")
+        return
+    }
+    scopes := make([]string, 0, len(reqParams.Scopes))
+    for _, scope := range reqParams.Scopes {
+        if !auth.IsValidScope(scope) {
+            SendJsonErrorResponse(res, http.StatusBadRequest, fmt.Sprintf("unknown scope %v", scope))
+            return
+        }
+        if !slices.Contains(scopes, scope) { scopes = append(scopes, scope) }
+    }
+
+    key, err := auth.MakeApiKey()
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to create api key")
+        return
+    }
+    params := database.CreateApiKeyParams {
+        UserID: userId,
+        Name: name,
+        KeyHash: auth.HashToken(key),
+        KeyPrefix: auth.GetApiKeyDisplayPrefix(key),
+        Scopes: scopes,
+    }
+    apiKey, err := cfg.Db.CreateApiKey(req.Context(), params)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to create api key")
+        fmt.Printf("Failed to create api key for user %v: %v\n", userId, err)
+        return
+    }
+
+    resApiKey := MakeResponseApiKey(apiKey)
+    resApiKey.Key = key
+    SendJsonResponse(res, http.StatusCreated, resApiKey)
+}
+
+// The authenticated user's API keys, newest first
+func (cfg *ApiConfig) HandleGetApiKeys(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    apiKeys, err := cfg.Db.GetUserApiKeys(req.Context(), userId)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get api keys")
+        fmt.Printf("Failed to retrieve api keys of user %v: %v\n", userId, err)
+        return
+    }
+
+    resApiKeys := make([]ResponseApiKey, 0, len(apiKeys))
+    for _, apiKey := range apiKeys { resApiKeys = append(resApiKeys, MakeResponseApiKey(apiKey)) }
+    SendJsonResponse(res, http.StatusOK, resApiKeys)
+}
+
+// Revoke a key. Requests made with it are rejected immediately.
+func (cfg *ApiConfig) HandleDeleteApiKey(res http.ResponseWriter, req *http.Request) {
+    idUuid, err := uuid.Parse(req.PathValue("id"))
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid uuid")
+        return
+    }
+
+    userId := GetAuthenticatedUserId(req)
+
+    params := database.DeleteUserApiKeyParams { ID: idUuid, UserID: userId }
+    deleted, err := cfg.Db.DeleteUserApiKey(req.Context(), params)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to delete api key")
+        fmt.Printf("Failed to delete api key %v of user %v: %v\n", idUuid, userId, err)
+        return
+    }
+    if deleted == 0 {
+        SendJsonErrorResponse(res, http.StatusNotFound, "api key not found")
+        return
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
@@ -1,88 +1,388 @@
 package main
 
 import (
+    "context"
     "strings"
     "net/http"
+    "net/url"
     "fmt"
+    "html"
+    "time"
+    "strconv"
+    "errors"
+    "database/sql"
     "github.com/google/uuid"
     "github.com/vedaRadev/chirpy-boot.dev/internal/database"
 )
 
+const MAX_CHIRP_LEN int = 140
+
+type ResponseChirp struct {
+    ID          uuid.UUID   `json:"id"`
+    CreatedAt   time.Time   `json:"created_at"`
+    UpdatedAt   time.Time   `json:"updated_at"`
+    Body        string      `json:"body"`
+    UserID      uuid.UUID   `json:"user_id"`
+    Author      *ResponsePublicUser `json:"author"`
+    // null if the chirp has never been edited
+    EditedAt    *time.Time  `json:"edited_at"`
+    InReplyTo   *uuid.UUID  `json:"in_reply_to"`
+    // Plain rechirps have an empty body, the re-shared chirp is in Original
+    RechirpOf   *uuid.UUID  `json:"rechirp_of"`
+    QuoteOf     *uuid.UUID  `json:"quote_of"`
+    // The rechirped or quoted chirp
+    Original    *ResponseChirp  `json:"original"`
+    LikeCount   int64       `json:"like_count"`
+    // Always false for anonymous requests
+    LikedByMe   bool        `json:"liked_by_me"`
+}
+
+func MakeResponseChirp(chirp database.Chirp) ResponseChirp {
+    responseChirp := ResponseChirp {
+        ID: chirp.ID,
+        CreatedAt: chirp.CreatedAt,
+        UpdatedAt: chirp.UpdatedAt,
+        Body: chirp.Body,
+        UserID: chirp.UserID,
+    }
+    if chirp.EditedAt.Valid { responseChirp.EditedAt = &chirp.EditedAt.Time }
+    if chirp.ParentID.Valid { responseChirp.InReplyTo = &chirp.ParentID.UUID }
+    if chirp.RechirpOf.Valid { responseChirp.RechirpOf = &chirp.RechirpOf.UUID }
+    if chirp.QuoteOf.Valid { responseChirp.QuoteOf = &chirp.QuoteOf.UUID }
+    return responseChirp
+}
+
+func MakeResponseChirps(chirps []database.Chirp) []ResponseChirp {
+    responseChirps := make([]ResponseChirp, 0, len(chirps))
+    for _, chirp := range chirps { responseChirps = append(responseChirps, MakeResponseChirp(chirp)) }
+    return responseChirps
+}
+
+// Fill in everything on chirp responses that doesn't come from the chirps' own rows
+func (cfg *ApiConfig) PopulateChirps(ctx context.Context, viewerId uuid.NullUUID, chirps ...*ResponseChirp) error {
+    originals, err := cfg.PopulateChirpOriginals(ctx, chirps...)
+    if err != nil { return err }
+
+    allChirps := make([]*ResponseChirp, 0, len(chirps) + len(originals))
+    allChirps = append(allChirps, chirps...)
+    allChirps = append(allChirps, originals...)
+    if err := cfg.PopulateChirpAuthors(ctx, allChirps...); err != nil { return err }
+    return cfg.PopulateChirpLikes(ctx, viewerId, allChirps...)
+}
+
+// Attach the public profile of each chirp's author so clients don't have to look them up one by one
+func (cfg *ApiConfig) PopulateChirpAuthors(ctx context.Context, chirps ...*ResponseChirp) error {
+    if len(chirps) == 0 { return nil }
+
+    userIds := make([]uuid.UUID, 0, len(chirps))
+    for _, chirp := range chirps { userIds = append(userIds, chirp.UserID) }
+    users, err := cfg.Db.GetUsersByIds(ctx, userIds)
+    if err != nil { return err }
+
+    authors := make(map[uuid.UUID]ResponsePublicUser, len(users))
+    for _, user := range users { authors[user.ID] = MakeResponsePublicUser(user) }
+    for _, chirp := range chirps {
+        if author, ok := authors[chirp.UserID]; ok { chirp.Author = &author }
+    }
+
+    return nil
+}
+
+func chirpPointers(chirps []ResponseChirp) []*ResponseChirp {
+    pointers := make([]*ResponseChirp, 0, len(chirps))
+    for i := range chirps { pointers = append(pointers, &chirps[i]) }
+    return pointers
+}
+
+// Check that a chirp body is within the length limit and censor any profanity. Returns the cleaned
+// body that should be stored.
+func ValidateChirpBody(body string) (string, error) {
+    if len(body) > MAX_CHIRP_LEN { return "", errors.New("chirp is too long") }
+
+    words := strings.Split(body, " ")
+    for i := range words {
+        lower := strings.ToLower(words[i])
+        if lower == "kerfuffle" || lower == "sharbert" || lower == "fornax" {
+            words[i] = "****"
+        }
+    }
+
+    return strings.Join(words, " "), nil
+}
+
 func (cfg *ApiConfig) HandleCreateChirp(res http.ResponseWriter, req *http.Request) {
-    type RequestParameters struct  { Body string `json:"body"` }
+    type RequestParameters struct  {
+        Body string `json:"body"`
+        InReplyTo *uuid.UUID `json:"in_reply_to"`
+        QuoteOf *uuid.UUID `json:"quote_of"`
+    }
     var reqParams RequestParameters
     if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
         SendJsonErrorResponse(res, errCode, err.Error())
         return
     }
 
-    userId, err, errCode := GetAuthenticatedUserId(req.Header, cfg.Secret)
-    if err != nil {
+    userId := GetAuthenticatedUserId(req)
+    if err, errCode := cfg.checkCanChirp(req.Context(), userId); err != nil {
         SendJsonErrorResponse(res, errCode, err.Error())
         return
     }
 
-    // TODO pull out into helper, maybe just have it return a boolean
-    const MAX_CHIRP_LEN int = 140
-    if len(reqParams.Body) > MAX_CHIRP_LEN {
-        SendJsonErrorResponse(res, http.StatusBadRequest, "chirp is too long")
+    cleaned, err := ValidateChirpBody(reqParams.Body)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
         return
     }
 
-    // TODO pull out into helper, return the cleaned chirp body as a string
-    // Filter profanity
-    words := strings.Split(reqParams.Body, " ")
-    for i := range words {
-        lower := strings.ToLower(words[i])
-        if lower == "kerfuffle" || lower == "sharbert" || lower == "fornax" {
-            words[i] = "****"
+    params := database.CreateChirpParams { Body: cleaned, UserID: userId }
+    var parent database.Chirp
+    if reqParams.InReplyTo != nil {
+        parent, err = cfg.getOriginalChirp(req.Context(), *reqParams.InReplyTo)
+        if err != nil {
+            SendJsonErrorResponse(res, http.StatusBadRequest, "the chirp being replied to does not exist")
+            return
         }
+        params.ParentID = uuid.NullUUID { UUID: parent.ID, Valid: true }
+    }
+    if reqParams.QuoteOf != nil {
+        if cleaned == "" {
+            SendJsonErrorResponse(res, http.StatusBadRequest, "quotes need a body, use a rechirp instead")
+            return
+        }
+        quoted, err := cfg.getOriginalChirp(req.Context(), *reqParams.QuoteOf)
+        if err != nil {
+            SendJsonErrorResponse(res, http.StatusBadRequest, "the chirp being quoted does not exist")
+            return
+        }
+        params.QuoteOf = uuid.NullUUID { UUID: quoted.ID, Valid: true }
     }
-    cleaned := strings.Join(words, " ")
-
-    params := database.CreateChirpParams { Body: cleaned, UserID: userId }
     chirp, err := cfg.Db.CreateChirp(req.Context(), params)
     if err != nil {
         SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to create chirp")
         fmt.Printf(`Failed to create chirp in db: user %v, chirp "%v"\n`, userId, cleaned)
         return
     }
+    // The chirp is already posted at this point, missing hashtags or notifications aren't worth failing
+    // the request over
+    if err := cfg.TagChirp(req.Context(), chirp); err != nil {
+        fmt.Printf("Failed to tag chirp %v with its hashtags: %v\n", chirp.ID, err)
+    }
+    if err := cfg.RecordMentions(req.Context(), chirp); err != nil {
+        fmt.Printf("Failed to record mentions in chirp %v: %v\n", chirp.ID, err)
+    }
+    if chirp.ParentID.Valid {
+        if err := cfg.Notify(req.Context(), parent.UserID, userId, NOTIFICATION_REPLY, chirp.ID); err != nil {
+            fmt.Printf("Failed to notify user %v of reply %v: %v\n", parent.UserID, chirp.ID, err)
+        }
+    }
 
-    SendJsonResponse(res, http.StatusCreated, chirp)
+    responseChirp := MakeResponseChirp(chirp)
+    viewerId := uuid.NullUUID { UUID: userId, Valid: true }
+    if err := cfg.PopulateChirps(req.Context(), viewerId, &responseChirp); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get chirp details")
+        return
+    }
+    SendJsonResponse(res, http.StatusCreated, responseChirp)
+}
+
+type ResponseChirpPage struct {
+    Chirps      []ResponseChirp     `json:"chirps"`
+    NextCursor  *string             `json:"next_cursor"`
+    PrevCursor  *string             `json:"prev_cursor"`
+}
+
+// Parse an optional RFC 3339 timestamp query parameter
+func getTimeQueryValue(queryValues url.Values, key string) (sql.NullTime, error) {
+    value := queryValues.Get(key)
+    if value == "" { return sql.NullTime {}, nil }
+    parsed, err := time.Parse(time.RFC3339, value)
+    if err != nil { return sql.NullTime {}, fmt.Errorf("invalid %s timestamp, expected RFC 3339", key) }
+    return sql.NullTime { Time: dbTime(parsed), Valid: true }, nil
 }
 
 func (cfg *ApiConfig) HandleGetChirps(res http.ResponseWriter, req *http.Request) {
-    var chirps []database.Chirp
     var err error
+    var params database.ListChirpsParams
 
-    queryValues := req.URL.Query()
+    viewerId := GetOptionalAuthenticatedUserId(req)
 
-    authorId := queryValues.Get("author_id")
-    sortOrder := strings.ToUpper(queryValues.Get("sort"))
-    if sortOrder == "" || (sortOrder != "ASC" && sortOrder != "DESC") { sortOrder = "ASC" }
+    queryValues := req.URL.Query()
 
-    if authorId == "" {
-        getChirps := cfg.Db.GetChirps
-        if sortOrder == "DESC" { getChirps = cfg.Db.GetChirpsDesc }
-        chirps, err = getChirps(req.Context())
-    } else {
+    if authorId := queryValues.Get("author_id"); authorId != "" {
         userId, err := uuid.Parse(authorId)
         if err != nil {
             SendJsonErrorResponse(res, http.StatusBadRequest, "invalid author id")
             return
         }
+        params.AuthorID = uuid.NullUUID { UUID: userId, Valid: true }
+    }
+    if params.Since, err = getTimeQueryValue(queryValues, "since"); err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+    if params.Until, err = getTimeQueryValue(queryValues, "until"); err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+    params.Contains = queryValues.Get("contains")
 
-        getUserChirps := cfg.Db.GetUserChirps
-        if sortOrder == "DESC" { getUserChirps = cfg.Db.GetUserChirpsDesc }
-        chirps, err = getUserChirps(req.Context(), userId)
+    sortOrder := strings.ToUpper(queryValues.Get("sort"))
+    if sortOrder == "" || (sortOrder != "ASC" && sortOrder != "DESC") { sortOrder = "ASC" }
+    params.Descending = sortOrder == "DESC"
+
+    cfg.SendChirpPage(res, req, params, viewerId)
+}
+
+// Home timeline for the authenticated user: chirps from everyone they follow, newest first
+func (cfg *ApiConfig) HandleGetTimeline(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    params := database.ListChirpsParams {
+        FollowedBy: uuid.NullUUID { UUID: userId, Valid: true },
+        Descending: true,
     }
+    cfg.SendChirpPage(res, req, params, uuid.NullUUID { UUID: userId, Valid: true })
+}
+
+// Applies the page parameters from the request's query to a chirp listing and sends the resulting
+// page. params.Descending should be the sort order the client asked for.
+func (cfg *ApiConfig) SendChirpPage(
+    res http.ResponseWriter,
+    req *http.Request,
+    params database.ListChirpsParams,
+    viewerId uuid.NullUUID,
+) {
+    limit, cursor, err := GetPageParameters(req.URL.Query())
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+    // Walking back a page means reading in the opposite direction of the requested sort order
+    if cursor != nil {
+        if cursor.Backward { params.Descending = !params.Descending }
+        params.CursorCreatedAt = sql.NullTime { Time: cursor.CreatedAt, Valid: true }
+        params.CursorID = cursor.ID
+    }
+    // Fetch one extra row to find out if there's another page
+    params.Limit = int32(limit + 1)
+
+    chirps, err := cfg.Db.ListChirps(req.Context(), params)
     if err != nil {
         SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get chirps")
         fmt.Printf("Failed to retrieve chirps from databse: %v\n", err)
         return
     }
 
-    SendJsonResponse(res, http.StatusOK, chirps)
+    chirps, nextCursor, prevCursor := PaginateResults(
+        chirps,
+        limit,
+        cursor,
+        func(chirp database.Chirp) (time.Time, uuid.UUID) { return chirp.CreatedAt, chirp.ID },
+    )
+    responseChirps := MakeResponseChirps(chirps)
+    if err := cfg.PopulateChirps(req.Context(), viewerId, chirpPointers(responseChirps)...); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get chirp details")
+        fmt.Printf("Failed to retrieve chirp details: %v\n", err)
+        return
+    }
+
+    SetPaginationLinks(res, req, nextCursor, prevCursor)
+    SendJsonResponse(res, http.StatusOK, ResponseChirpPage {
+        Chirps: responseChirps,
+        NextCursor: nextCursor,
+        PrevCursor: prevCursor,
+    })
+}
+
+type ResponseChirpSearchResult struct {
+    ResponseChirp
+    Rank        float32     `json:"rank"`
+    // The chirp body with matching terms wrapped in <mark></mark>, everything else is HTML escaped
+    Snippet     string      `json:"snippet"`
+}
+
+type ResponseChirpSearch struct {
+    Results     []ResponseChirpSearchResult `json:"results"`
+    NextOffset  *int                        `json:"next_offset"`
+}
+
+func (cfg *ApiConfig) HandleSearchChirps(res http.ResponseWriter, req *http.Request) {
+    viewerId := GetOptionalAuthenticatedUserId(req)
+
+    queryValues := req.URL.Query()
+
+    query := strings.TrimSpace(queryValues.Get("q"))
+    if query == "" {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "missing search query")
+        return
+    }
+
+    // Results are ordered by rank rather than by time so they're paged by offset instead of by cursor
+    limit, err := GetPageLimit(queryValues)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+    offset := 0
+    if offsetStr := queryValues.Get("offset"); offsetStr != "" {
+        // Postgres takes the offset as a 32 bit integer, anything bigger would wrap around
+        parsed, err := strconv.ParseInt(offsetStr, 10, 32)
+        if err != nil || parsed < 0 {
+            SendJsonErrorResponse(res, http.StatusBadRequest, "invalid offset")
+            return
+        }
+        offset = int(parsed)
+    }
+
+    rows, err := cfg.Db.SearchChirps(req.Context(), database.SearchChirpsParams {
+        Query: query,
+        PageLimit: int32(limit + 1),
+        PageOffset: int32(offset),
+    })
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to search chirps")
+        fmt.Printf("Failed to search chirps for %q: %v\n", query, err)
+        return
+    }
+
+    var nextOffset *int
+    if len(rows) > limit {
+        rows = rows[:limit]
+        next := offset + limit
+        nextOffset = &next
+    }
+
+    // ts_headline doesn't escape anything so the body has to be escaped here. Matches are marked with control
+    // characters that can't be in the snippet otherwise and only become tags after escaping.
+    highlightMatches := strings.NewReplacer("\x02", "<mark>", "\x03", "</mark>")
+    results := make([]ResponseChirpSearchResult, 0, len(rows))
+    for _, row := range rows {
+        results = append(results, ResponseChirpSearchResult {
+            ResponseChirp: MakeResponseChirp(database.Chirp {
+                ID: row.ID,
+                CreatedAt: row.CreatedAt,
+                UpdatedAt: row.UpdatedAt,
+                Body: row.Body,
+                UserID: row.UserID,
+                EditedAt: row.EditedAt,
+                ParentID: row.ParentID,
+                RechirpOf: row.RechirpOf,
+                QuoteOf: row.QuoteOf,
+            }),
+            Rank: row.Rank,
+            Snippet: highlightMatches.Replace(html.EscapeString(row.Snippet)),
+        })
+    }
+
+    resultChirps := make([]*ResponseChirp, 0, len(results))
+    for i := range results { resultChirps = append(resultChirps, &results[i].ResponseChirp) }
+    if err := cfg.PopulateChirps(req.Context(), viewerId, resultChirps...); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get chirp details")
+        fmt.Printf("Failed to retrieve chirp details: %v\n", err)
+        return
+    }
+
+    SendJsonResponse(res, http.StatusOK, ResponseChirpSearch { Results: results, NextOffset: nextOffset })
 }
 
 func (cfg *ApiConfig) HandleGetChirp(res http.ResponseWriter, req *http.Request) {
@@ -93,16 +393,23 @@ func (cfg *ApiConfig) HandleGetChirp(res http.ResponseWriter, req *http.Request)
         return
     }
 
+    viewerId := GetOptionalAuthenticatedUserId(req)
+
     chirp, err := cfg.Db.GetChirp(req.Context(), idUuid)
     if err != nil {
         SendJsonErrorResponse(res, http.StatusNotFound, "failed to get chirp")
         return
     }
 
-    SendJsonResponse(res, http.StatusOK, chirp)
+    responseChirp := MakeResponseChirp(chirp)
+    if err := cfg.PopulateChirps(req.Context(), viewerId, &responseChirp); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get chirp details")
+        return
+    }
+    SendJsonResponse(res, http.StatusOK, responseChirp)
 }
 
-func (cfg *ApiConfig) HandleDeleteChirp(res http.ResponseWriter, req *http.Request) {
+func (cfg *ApiConfig) HandleEditChirp(res http.ResponseWriter, req *http.Request) {
     idStr := req.PathValue("id")
     idUuid, err := uuid.Parse(idStr)
     if err != nil {
@@ -110,11 +417,206 @@ func (cfg *ApiConfig) HandleDeleteChirp(res http.ResponseWriter, req *http.Reque
         return
     }
 
-    authenticatedUserId, err, errCode := GetAuthenticatedUserId(req.Header, cfg.Secret)
-    if err != nil {
+    authenticatedUserId := GetAuthenticatedUserId(req)
+
+    type RequestParameters struct  { Body string `json:"body"` }
+    var reqParams RequestParameters
+    if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
         SendJsonErrorResponse(res, errCode, err.Error())
         return
     }
+    cleaned, err := ValidateChirpBody(reqParams.Body)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    chirp, err := cfg.Db.GetChirp(req.Context(), idUuid)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "chirp not found")
+        return
+    }
+    if chirp.UserID != authenticatedUserId {
+        SendJsonErrorResponse(res, http.StatusForbidden, "forbidden")
+        return
+    }
+    if chirp.RechirpOf.Valid {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "rechirps can't be edited")
+        return
+    }
+    // Don't pile up revisions that are identical to the current body
+    if cleaned != chirp.Body {
+        params := database.UpdateChirpBodyParams { ID: chirp.ID, Body: cleaned }
+        chirp, err = cfg.Db.UpdateChirpBody(req.Context(), params)
+        if err != nil {
+            SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to edit chirp")
+            fmt.Printf("Failed to edit chirp %v: %v\n", idUuid, err)
+            return
+        }
+        if err := cfg.TagChirp(req.Context(), chirp); err != nil {
+            fmt.Printf("Failed to tag chirp %v with its hashtags: %v\n", chirp.ID, err)
+        }
+        if err := cfg.RecordMentions(req.Context(), chirp); err != nil {
+            fmt.Printf("Failed to record mentions in chirp %v: %v\n", chirp.ID, err)
+        }
+    }
+
+    responseChirp := MakeResponseChirp(chirp)
+    viewerId := uuid.NullUUID { UUID: authenticatedUserId, Valid: true }
+    if err := cfg.PopulateChirps(req.Context(), viewerId, &responseChirp); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get chirp details")
+        return
+    }
+    SendJsonResponse(res, http.StatusOK, responseChirp)
+}
+
+const DEFAULT_THREAD_DEPTH int = 5
+const MAX_THREAD_DEPTH int = 20
+// Caps the size of the reply tree for chirps with a huge number of replies
+const MAX_THREAD_REPLIES int = 500
+
+type ResponseChirpThreadNode struct {
+    ResponseChirp
+    Replies []*ResponseChirpThreadNode `json:"replies"`
+}
+
+type ResponseChirpThread struct {
+    // The chirps above the requested one, starting from the root of the thread
+    Ancestors   []ResponseChirp             `json:"ancestors"`
+    Chirp       *ResponseChirpThreadNode    `json:"chirp"`
+}
+
+func (cfg *ApiConfig) HandleGetChirpThread(res http.ResponseWriter, req *http.Request) {
+    idStr := req.PathValue("id")
+    idUuid, err := uuid.Parse(idStr)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid uuid")
+        return
+    }
+
+    viewerId := GetOptionalAuthenticatedUserId(req)
+
+    depth := DEFAULT_THREAD_DEPTH
+    if depthStr := req.URL.Query().Get("depth"); depthStr != "" {
+        depth, err = strconv.Atoi(depthStr)
+        if err != nil || depth < 1 || depth > MAX_THREAD_DEPTH {
+            SendJsonErrorResponse(res, http.StatusBadRequest, fmt.Sprintf("depth must be between 1 and %d", MAX_THREAD_DEPTH))
+            return
+        }
+    }
+
+    chirp, err := cfg.Db.GetChirp(req.Context(), idUuid)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "chirp not found")
+        return
+    }
+
+    ancestorRows, err := cfg.Db.GetChirpAncestors(req.Context(), database.GetChirpAncestorsParams {
+        ID: chirp.ID,
+        MaxDepth: int32(depth),
+    })
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get chirp thread")
+        fmt.Printf("Failed to get ancestors of chirp %v: %v\n", chirp.ID, err)
+        return
+    }
+    descendantRows, err := cfg.Db.GetChirpDescendants(req.Context(), database.GetChirpDescendantsParams {
+        ID: chirp.ID,
+        MaxDepth: int32(depth),
+        MaxReplies: int32(MAX_THREAD_REPLIES),
+    })
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get chirp thread")
+        fmt.Printf("Failed to get descendants of chirp %v: %v\n", chirp.ID, err)
+        return
+    }
+
+    // Ancestors come back nearest first
+    ancestors := make([]ResponseChirp, len(ancestorRows))
+    for i, row := range ancestorRows {
+        ancestors[len(ancestorRows) - 1 - i] = MakeResponseChirp(database.Chirp {
+            ID: row.ID,
+            CreatedAt: row.CreatedAt,
+            UpdatedAt: row.UpdatedAt,
+            Body: row.Body,
+            UserID: row.UserID,
+            EditedAt: row.EditedAt,
+            ParentID: row.ParentID,
+            RechirpOf: row.RechirpOf,
+            QuoteOf: row.QuoteOf,
+        })
+    }
+
+    root := &ResponseChirpThreadNode { ResponseChirp: MakeResponseChirp(chirp), Replies: []*ResponseChirpThreadNode {} }
+    nodes := map[uuid.UUID]*ResponseChirpThreadNode { root.ID: root }
+    // Descendants are ordered parents first so every reply's parent is already in the tree
+    for _, row := range descendantRows {
+        parent, ok := nodes[row.ParentID.UUID]
+        if !ok { continue }
+        node := &ResponseChirpThreadNode {
+            ResponseChirp: MakeResponseChirp(database.Chirp {
+                ID: row.ID,
+                CreatedAt: row.CreatedAt,
+                UpdatedAt: row.UpdatedAt,
+                Body: row.Body,
+                UserID: row.UserID,
+                EditedAt: row.EditedAt,
+                ParentID: row.ParentID,
+                RechirpOf: row.RechirpOf,
+                QuoteOf: row.QuoteOf,
+            }),
+            Replies: []*ResponseChirpThreadNode {},
+        }
+        parent.Replies = append(parent.Replies, node)
+        nodes[node.ID] = node
+    }
+
+    threadChirps := make([]*ResponseChirp, 0, len(ancestors) + len(nodes))
+    for i := range ancestors { threadChirps = append(threadChirps, &ancestors[i]) }
+    for _, node := range nodes { threadChirps = append(threadChirps, &node.ResponseChirp) }
+    if err := cfg.PopulateChirps(req.Context(), viewerId, threadChirps...); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get chirp details")
+        fmt.Printf("Failed to retrieve chirp details: %v\n", err)
+        return
+    }
+
+    SendJsonResponse(res, http.StatusOK, ResponseChirpThread { Ancestors: ancestors, Chirp: root })
+}
+
+// Lists the previous bodies of a chirp, oldest first. Each revision's created_at is the time it was
+// replaced by an edit.
+func (cfg *ApiConfig) HandleGetChirpHistory(res http.ResponseWriter, req *http.Request) {
+    idStr := req.PathValue("id")
+    idUuid, err := uuid.Parse(idStr)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid uuid")
+        return
+    }
+
+    if _, err := cfg.Db.GetChirp(req.Context(), idUuid); err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "chirp not found")
+        return
+    }
+
+    revisions, err := cfg.Db.GetChirpRevisions(req.Context(), idUuid)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get chirp history")
+        return
+    }
+    if revisions == nil { revisions = []database.ChirpRevision {} }
+
+    SendJsonResponse(res, http.StatusOK, revisions)
+}
+
+func (cfg *ApiConfig) HandleDeleteChirp(res http.ResponseWriter, req *http.Request) {
+    idStr := req.PathValue("id")
+    idUuid, err := uuid.Parse(idStr)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid uuid")
+        return
+    }
+
+    authenticatedUserId := GetAuthenticatedUserId(req)
 
     chirp, err := cfg.Db.GetChirp(req.Context(), idUuid)
     if err != nil {
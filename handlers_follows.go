@@ -0,0 +1,162 @@
+package main
+
+import (
+    "net/http"
+    "time"
+    "fmt"
+    "database/sql"
+
+    "github.com/google/uuid"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+)
+
+type ResponseFollow struct {
+    UserID      uuid.UUID   `json:"user_id"`
+    FollowedAt  time.Time   `json:"followed_at"`
+}
+
+type ResponseFollowPage struct {
+    Users       []ResponseFollow    `json:"users"`
+    NextCursor  *string             `json:"next_cursor"`
+}
+
+// Parse the {id} path value and make sure the user exists
+func (cfg *ApiConfig) getPathUser(req *http.Request) (database.User, error, int) {
+    idUuid, err := uuid.Parse(req.PathValue("id"))
+    if err != nil {
+        return database.User {}, fmt.Errorf("invalid uuid"), http.StatusBadRequest
+    }
+    user, err := cfg.Db.GetUser(req.Context(), idUuid)
+    if err != nil {
+        return database.User {}, fmt.Errorf("user not found"), http.StatusNotFound
+    }
+    return user, nil, 0
+}
+
+func (cfg *ApiConfig) HandleFollowUser(res http.ResponseWriter, req *http.Request) {
+    followerId := GetAuthenticatedUserId(req)
+
+    followee, err, errCode := cfg.getPathUser(req)
+    if err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+    if followee.ID == followerId {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "you can't follow yourself")
+        return
+    }
+
+    // Following someone twice isn't an error, there's just nothing to do
+    params := database.FollowUserParams { FollowerID: followerId, FolloweeID: followee.ID }
+    if _, err := cfg.Db.FollowUser(req.Context(), params); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to follow user")
+        fmt.Printf("Failed to follow user %v for %v: %v\n", followee.ID, followerId, err)
+        return
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) HandleUnfollowUser(res http.ResponseWriter, req *http.Request) {
+    followerId := GetAuthenticatedUserId(req)
+
+    followee, err, errCode := cfg.getPathUser(req)
+    if err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+
+    params := database.UnfollowUserParams { FollowerID: followerId, FolloweeID: followee.ID }
+    if _, err := cfg.Db.UnfollowUser(req.Context(), params); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to unfollow user")
+        return
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
+
+// Shared by the follower and following listings. getOtherId picks out the id of the user on the other
+// side of the follow from the one being listed.
+func (cfg *ApiConfig) sendFollowPage(
+    res http.ResponseWriter,
+    req *http.Request,
+    getFollows func(database.User, sql.NullTime, uuid.NullUUID, int32) ([]database.Follow, error),
+    getOtherId func(database.Follow) uuid.UUID,
+) {
+    user, err, errCode := cfg.getPathUser(req)
+    if err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+
+    limit, cursor, err := GetPageParameters(req.URL.Query())
+    // Follow listings only ever hand out cursors to the next page
+    if err == nil && cursor != nil && cursor.Backward { err = fmt.Errorf("invalid cursor") }
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+    var beforeCreatedAt sql.NullTime
+    var beforeId uuid.NullUUID
+    if cursor != nil {
+        beforeCreatedAt = sql.NullTime { Time: cursor.CreatedAt, Valid: true }
+        beforeId = uuid.NullUUID { UUID: cursor.ID, Valid: true }
+    }
+
+    follows, err := getFollows(user, beforeCreatedAt, beforeId, int32(limit + 1))
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get follows")
+        fmt.Printf("Failed to retrieve follows of user %v: %v\n", user.ID, err)
+        return
+    }
+
+    follows, nextCursor, _ := PaginateResults(
+        follows,
+        limit,
+        cursor,
+        func(follow database.Follow) (time.Time, uuid.UUID) { return follow.CreatedAt, getOtherId(follow) },
+    )
+    responseFollows := make([]ResponseFollow, 0, len(follows))
+    for _, follow := range follows {
+        responseFollows = append(responseFollows, ResponseFollow {
+            UserID: getOtherId(follow),
+            FollowedAt: follow.CreatedAt,
+        })
+    }
+
+    SetPaginationLinks(res, req, nextCursor, nil)
+    SendJsonResponse(res, http.StatusOK, ResponseFollowPage { Users: responseFollows, NextCursor: nextCursor })
+}
+
+func (cfg *ApiConfig) HandleGetFollowers(res http.ResponseWriter, req *http.Request) {
+    cfg.sendFollowPage(
+        res,
+        req,
+        func(user database.User, beforeCreatedAt sql.NullTime, beforeId uuid.NullUUID, limit int32) ([]database.Follow, error) {
+            return cfg.Db.GetFollowers(req.Context(), database.GetFollowersParams {
+                UserID: user.ID,
+                BeforeCreatedAt: beforeCreatedAt,
+                BeforeID: beforeId,
+                PageLimit: limit,
+            })
+        },
+        func(follow database.Follow) uuid.UUID { return follow.FollowerID },
+    )
+}
+
+func (cfg *ApiConfig) HandleGetFollowing(res http.ResponseWriter, req *http.Request) {
+    cfg.sendFollowPage(
+        res,
+        req,
+        func(user database.User, beforeCreatedAt sql.NullTime, beforeId uuid.NullUUID, limit int32) ([]database.Follow, error) {
+            return cfg.Db.GetFollowing(req.Context(), database.GetFollowingParams {
+                UserID: user.ID,
+                BeforeCreatedAt: beforeCreatedAt,
+                BeforeID: beforeId,
+                PageLimit: limit,
+            })
+        },
+        func(follow database.Follow) uuid.UUID { return follow.FolloweeID },
+    )
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "fmt"
+    "regexp"
+    "strings"
+    "time"
+    "unicode"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+)
+
+const MAX_HASHTAG_LEN int = 100
+const DEFAULT_TRENDING_WINDOW time.Duration = 24 * time.Hour
+const MAX_TRENDING_WINDOW time.Duration = 30 * 24 * time.Hour
+
+// A # only starts a hashtag at the beginning of the body or after something that can't be part of a
+// word, so "issue#4" and "&#39;" aren't tags
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&])#([\p{L}\p{N}_]+)`)
+
+func NormalizeHashtag(tag string) string {
+    return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// Pull the distinct, normalized hashtags out of a chirp body. Tags need at least one letter so
+// things like "#1" are left alone.
+func ExtractHashtags(body string) []string {
+    var tags []string
+    seen := make(map[string]bool)
+    for _, match := range hashtagPattern.FindAllStringSubmatch(body, -1) {
+        tag := NormalizeHashtag(match[1])
+        if len(tag) > MAX_HASHTAG_LEN || seen[tag] || !strings.ContainsFunc(tag, unicode.IsLetter) { continue }
+        seen[tag] = true
+        tags = append(tags, tag)
+    }
+    return tags
+}
+
+// Replace whatever hashtags a chirp was tagged with by the ones currently in its body. Done in one
+// transaction so a failure partway through leaves the old tags in place.
+func (cfg *ApiConfig) TagChirp(ctx context.Context, chirp database.Chirp) error {
+    return cfg.WithTx(ctx, func(queries *database.Queries) error {
+        if err := queries.ClearChirpHashtags(ctx, chirp.ID); err != nil { return err }
+
+        for _, tag := range ExtractHashtags(chirp.Body) {
+            hashtag, err := queries.UpsertHashtag(ctx, tag)
+            if err != nil { return err }
+            params := database.AddChirpHashtagParams { ChirpID: chirp.ID, HashtagID: hashtag.ID }
+            if err := queries.AddChirpHashtag(ctx, params); err != nil { return err }
+        }
+
+        return nil
+    })
+}
+
+func (cfg *ApiConfig) HandleGetHashtagChirps(res http.ResponseWriter, req *http.Request) {
+    viewerId := GetOptionalAuthenticatedUserId(req)
+
+    tag := NormalizeHashtag(req.PathValue("tag"))
+    if tag == "" {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid hashtag")
+        return
+    }
+
+    // Newest first unless asked otherwise
+    params := database.ListChirpsParams {
+        Hashtag: tag,
+        Descending: strings.ToUpper(req.URL.Query().Get("sort")) != "ASC",
+    }
+    cfg.SendChirpPage(res, req, params, viewerId)
+}
+
+type ResponseTrendingHashtag struct {
+    Tag         string  `json:"tag"`
+    ChirpCount  int64   `json:"chirp_count"`
+}
+
+type ResponseTrendingHashtags struct {
+    Since       time.Time                   `json:"since"`
+    Hashtags    []ResponseTrendingHashtag   `json:"hashtags"`
+}
+
+// The most used hashtags on chirps posted within a trailing window (e.g. ?window=6h)
+func (cfg *ApiConfig) HandleGetTrendingHashtags(res http.ResponseWriter, req *http.Request) {
+    queryValues := req.URL.Query()
+
+    window := DEFAULT_TRENDING_WINDOW
+    if windowStr := queryValues.Get("window"); windowStr != "" {
+        parsed, err := time.ParseDuration(windowStr)
+        if err != nil || parsed <= 0 || parsed > MAX_TRENDING_WINDOW {
+            SendJsonErrorResponse(res, http.StatusBadRequest, fmt.Sprintf("window must be a duration up to %v", MAX_TRENDING_WINDOW))
+            return
+        }
+        window = parsed
+    }
+    limit, err := GetPageLimit(queryValues)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    since := dbNow().Add(-window)
+    params := database.GetTrendingHashtagsParams { Since: since, PageLimit: int32(limit) }
+    trending, err := cfg.Db.GetTrendingHashtags(req.Context(), params)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get trending hashtags")
+        fmt.Printf("Failed to retrieve trending hashtags: %v\n", err)
+        return
+    }
+
+    hashtags := make([]ResponseTrendingHashtag, 0, len(trending))
+    for _, row := range trending {
+        hashtags = append(hashtags, ResponseTrendingHashtag { Tag: row.Tag, ChirpCount: row.ChirpCount })
+    }
+    SendJsonResponse(res, http.StatusOK, ResponseTrendingHashtags { Since: since, Hashtags: hashtags })
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+    "testing"
+    "slices"
+    "strings"
+)
+
+func TestExtractHashtags(t *testing.T) {
+    longestTag := strings.Repeat("a", MAX_HASHTAG_LEN)
+
+    type TestCase struct {
+        body string
+        expected []string
+    }
+    testCases := []TestCase {
+        { body: "", expected: nil },
+        { body: "no tags here", expected: nil },
+        { body: "#go at the start", expected: []string { "go" } },
+        { body: "ending with #go", expected: []string { "go" } },
+        { body: "#Go #golang #GO", expected: []string { "go", "golang" } },
+        { body: "snake #snake_case and #under_", expected: []string { "snake_case", "under_" } },
+        // Tags need a letter
+        { body: "#1 #2024 #_ #v2", expected: []string { "v2" } },
+        { body: "#café #日本", expected: []string { "café", "日本" } },
+        // Not preceded by something that can't be part of a word
+        { body: "issue#4 a#b &#39; x_#y", expected: nil },
+        // Punctuation ends a tag and can come right before one
+        { body: "(#go), #rust. #zig! #odin's \"#c\"", expected: []string { "go", "rust", "zig", "odin", "c" } },
+        { body: "#just #", expected: []string { "just" } },
+        { body: "#" + longestTag, expected: []string { longestTag } },
+        { body: "#" + longestTag + "a #ok", expected: []string { "ok" } },
+    }
+
+    for i, testCase := range testCases {
+        if actual := ExtractHashtags(testCase.body); !slices.Equal(actual, testCase.expected) {
+            t.Errorf("Test case %v: expected %v, got %v\n", i, testCase.expected, actual)
+        }
+    }
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+    "net/http"
+)
+
+// The public keys access tokens can be verified with. Keys that were rotated out of signing are still listed
+// until they're removed from the key directory, so tokens they signed can be verified until they expire.
+func (cfg *ApiConfig) HandleGetJwks(res http.ResponseWriter, req *http.Request) {
+    res.Header().Set("Cache-Control", "public, max-age=300")
+    SendJsonResponse(res, http.StatusOK, cfg.Keys.JWKS())
+}
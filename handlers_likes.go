@@ -0,0 +1,91 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "fmt"
+
+    "github.com/google/uuid"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+)
+
+// Fill in the like counts of the given chirps, and whether the viewer liked them if there is one
+func (cfg *ApiConfig) PopulateChirpLikes(ctx context.Context, viewerId uuid.NullUUID, chirps ...*ResponseChirp) error {
+    if len(chirps) == 0 { return nil }
+
+    chirpIds := make([]uuid.UUID, 0, len(chirps))
+    for _, chirp := range chirps { chirpIds = append(chirpIds, chirp.ID) }
+    params := database.GetChirpLikeStatsParams { ViewerID: viewerId, ChirpIds: chirpIds }
+    stats, err := cfg.Db.GetChirpLikeStats(ctx, params)
+    if err != nil { return err }
+
+    statsByChirp := make(map[uuid.UUID]database.GetChirpLikeStatsRow, len(stats))
+    for _, stat := range stats { statsByChirp[stat.ChirpID] = stat }
+    for _, chirp := range chirps {
+        stat := statsByChirp[chirp.ID]
+        chirp.LikeCount = stat.LikeCount
+        chirp.LikedByMe = stat.LikedByViewer
+    }
+
+    return nil
+}
+
+func (cfg *ApiConfig) HandleLikeChirp(res http.ResponseWriter, req *http.Request) {
+    idStr := req.PathValue("id")
+    idUuid, err := uuid.Parse(idStr)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid uuid")
+        return
+    }
+
+    userId := GetAuthenticatedUserId(req)
+
+    chirp, err := cfg.getOriginalChirp(req.Context(), idUuid)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "chirp not found")
+        return
+    }
+
+    // Liking a chirp twice isn't an error, there's just nothing to do
+    params := database.LikeChirpParams { UserID: userId, ChirpID: chirp.ID }
+    liked, err := cfg.Db.LikeChirp(req.Context(), params)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to like chirp")
+        fmt.Printf("Failed to like chirp %v for user %v: %v\n", chirp.ID, userId, err)
+        return
+    }
+    if liked > 0 {
+        if err := cfg.Notify(req.Context(), chirp.UserID, userId, NOTIFICATION_LIKE, chirp.ID); err != nil {
+            fmt.Printf("Failed to notify user %v of like on chirp %v: %v\n", chirp.UserID, chirp.ID, err)
+        }
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) HandleUnlikeChirp(res http.ResponseWriter, req *http.Request) {
+    idStr := req.PathValue("id")
+    idUuid, err := uuid.Parse(idStr)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid uuid")
+        return
+    }
+
+    userId := GetAuthenticatedUserId(req)
+
+    chirp, err := cfg.getOriginalChirp(req.Context(), idUuid)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "chirp not found")
+        return
+    }
+
+    params := database.UnlikeChirpParams { UserID: userId, ChirpID: chirp.ID }
+    if _, err := cfg.Db.UnlikeChirp(req.Context(), params); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to unlike chirp")
+        return
+    }
+    // The like notification stays, otherwise liking again would notify the author again
+
+    res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "time"
+    "fmt"
+    "errors"
+    "database/sql"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/auth"
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+)
+
+// How long the user has to enter their code after getting the password right
+const MFA_CHALLENGE_EXPIRY time.Duration = 5 * time.Minute
+// Shown next to the account in authenticator apps
+const TOTP_ISSUER string = "Chirpy"
+
+type ResponseMfaChallenge struct {
+    MfaRequired bool    `json:"mfa_required"`
+    MfaToken    string  `json:"mfa_token"`
+}
+
+type ResponseTotpEnrollment struct {
+    Secret  string  `json:"secret"`
+    Uri     string  `json:"uri"`
+}
+
+type ResponseRecoveryCodes struct {
+    RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Check a code from the user's authenticator app, or one of their recovery codes. Either kind of code is
+// used up by a successful check.
+func (cfg *ApiConfig) checkSecondFactor(ctx context.Context, user database.User, code string) (bool, error) {
+    if !user.TotpSecret.Valid { return false, nil }
+
+    if step, ok := auth.ValidateTotpCode(user.TotpSecret.String, code, time.Now()); ok {
+        params := database.UseTotpStepParams { ID: user.ID, TotpLastUsedStep: sql.NullInt64 { Int64: step, Valid: true } }
+        used, err := cfg.Db.UseTotpStep(ctx, params)
+        return used == 1, err
+    }
+
+    params := database.UseMfaRecoveryCodeParams {
+        UserID: user.ID,
+        CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+    }
+    used, err := cfg.Db.UseMfaRecoveryCode(ctx, params)
+    return used == 1, err
+}
+
+// Start turning on two-factor auth. The returned secret (or the otpauth:// uri, usually shown as a QR code)
+// goes into an authenticator app, then a code from the app has to be sent to HandleConfirmTotpEnrollment.
+// Starting again before confirming replaces the secret.
+func (cfg *ApiConfig) HandleStartTotpEnrollment(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    type RequestParameters struct {
+        Password string `json:"password"`
+    }
+    var reqParams RequestParameters
+    if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+
+    user, err := cfg.Db.GetUser(req.Context(), userId)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "user not found")
+        return
+    }
+    // Otherwise a stolen access token would be enough to lock the owner out by enrolling another device
+    if !cfg.checkReenteredPassword(res, req, user, reqParams.Password, "incorrect password") { return }
+    if user.TotpEnabledAt.Valid {
+        SendJsonErrorResponse(res, http.StatusConflict, "two-factor authentication is already enabled")
+        return
+    }
+
+    secret, err := auth.GenerateTotpSecret()
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to create totp secret")
+        return
+    }
+    params := database.StartTotpEnrollmentParams { ID: user.ID, TotpSecret: sql.NullString { String: secret, Valid: true } }
+    started, err := cfg.Db.StartTotpEnrollment(req.Context(), params)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to start two-factor enrollment")
+        fmt.Printf("Failed to start totp enrollment of user %v: %v\n", user.ID, err)
+        return
+    }
+    if started == 0 {
+        SendJsonErrorResponse(res, http.StatusConflict, "two-factor authentication is already enabled")
+        return
+    }
+
+    SendJsonResponse(res, http.StatusOK, ResponseTotpEnrollment {
+        Secret: secret,
+        Uri: auth.MakeTotpUri(TOTP_ISSUER, user.Email, secret),
+    })
+}
+
+// Turn on two-factor auth with a first code from the authenticator app. Responds with the recovery codes,
+// which can't be retrieved again.
+func (cfg *ApiConfig) HandleConfirmTotpEnrollment(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    type RequestParameters struct {
+        Code string `json:"code"`
+    }
+    var reqParams RequestParameters
+    if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+
+    user, err := cfg.Db.GetUser(req.Context(), userId)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "user not found")
+        return
+    }
+    if user.TotpEnabledAt.Valid {
+        SendJsonErrorResponse(res, http.StatusConflict, "two-factor authentication is already enabled")
+        return
+    }
+    if !user.TotpSecret.Valid {
+        SendJsonErrorResponse(res, http.StatusConflict, "two-factor enrollment hasn't been started")
+        return
+    }
+    step, ok := auth.ValidateTotpCode(user.TotpSecret.String, reqParams.Code, time.Now())
+    if !ok {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid code")
+        return
+    }
+
+    recoveryCodes, err := auth.MakeRecoveryCodes()
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to create recovery codes")
+        return
+    }
+
+    errAlreadyEnabled := errors.New("two-factor authentication is already enabled")
+    err = cfg.WithTx(req.Context(), func(queries *database.Queries) error {
+        params := database.EnableTotpParams { ID: user.ID, TotpLastUsedStep: sql.NullInt64 { Int64: step, Valid: true } }
+        enabled, err := queries.EnableTotp(req.Context(), params)
+        if err != nil { return err }
+        if enabled == 0 { return errAlreadyEnabled }
+
+        if err := queries.DeleteMfaRecoveryCodes(req.Context(), user.ID); err != nil { return err }
+        for _, recoveryCode := range recoveryCodes {
+            params := database.CreateMfaRecoveryCodeParams {
+                CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)),
+                UserID: user.ID,
+            }
+            if err := queries.CreateMfaRecoveryCode(req.Context(), params); err != nil { return err }
+        }
+        return nil
+    })
+    if errors.Is(err, errAlreadyEnabled) {
+        SendJsonErrorResponse(res, http.StatusConflict, err.Error())
+        return
+    }
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to enable two-factor authentication")
+        fmt.Printf("Failed to enable totp for user %v: %v\n", user.ID, err)
+        return
+    }
+
+    SendJsonResponse(res, http.StatusOK, ResponseRecoveryCodes { RecoveryCodes: recoveryCodes })
+}
+
+// Turn off two-factor auth. Needs both the password and a current code (or a recovery code).
+func (cfg *ApiConfig) HandleDisableTotp(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    type RequestParameters struct {
+        Password string `json:"password"`
+        Code string `json:"code"`
+    }
+    var reqParams RequestParameters
+    if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+
+    user, err := cfg.Db.GetUser(req.Context(), userId)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "user not found")
+        return
+    }
+    if !cfg.checkReenteredPassword(res, req, user, reqParams.Password, "incorrect password") { return }
+    if !user.TotpEnabledAt.Valid {
+        SendJsonErrorResponse(res, http.StatusConflict, "two-factor authentication isn't enabled")
+        return
+    }
+    ok, err := cfg.checkSecondFactor(req.Context(), user, reqParams.Code)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to check code")
+        fmt.Printf("Failed to check second factor of user %v: %v\n", user.ID, err)
+        return
+    }
+    if !ok {
+        SendJsonErrorResponse(res, http.StatusUnauthorized, "invalid code")
+        return
+    }
+
+    err = cfg.WithTx(req.Context(), func(queries *database.Queries) error {
+        if err := queries.DisableTotp(req.Context(), user.ID); err != nil { return err }
+        return queries.DeleteMfaRecoveryCodes(req.Context(), user.ID)
+    })
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to disable two-factor authentication")
+        fmt.Printf("Failed to disable totp for user %v: %v\n", user.ID, err)
+        return
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
+
+// Second step of logging in with two-factor auth: exchange the mfa token from HandleLogin and a code for
+// the access and refresh tokens
+func (cfg *ApiConfig) HandleLoginMfa(res http.ResponseWriter, req *http.Request) {
+    type RequestParameters struct {
+        MfaToken string `json:"mfa_token"`
+        Code string `json:"code"`
+    }
+    var reqParams RequestParameters
+    if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+
+    challenge, err := auth.ValidateMfaChallengeJWT(reqParams.MfaToken, cfg.Keys)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusUnauthorized, "invalid or expired mfa token")
+        return
+    }
+    user, err := cfg.Db.GetUser(req.Context(), challenge.UserID)
+    if err != nil || !user.TotpEnabledAt.Valid {
+        SendJsonErrorResponse(res, http.StatusUnauthorized, "invalid or expired mfa token")
+        return
+    }
+
+    // Wrong codes count as failed logins of the account, otherwise someone who knows the password could keep
+    // getting new mfa tokens and guess codes without limit
+    throttleKeys := getLoginThrottleKeys(user.Email, req)
+    if !cfg.beginLoginAttempt(res, req, throttleKeys) { return }
+    ok, err := cfg.checkSecondFactor(req.Context(), user, reqParams.Code)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to check code")
+        fmt.Printf("Failed to check second factor of user %v: %v\n", user.ID, err)
+        return
+    }
+    if !ok {
+        SendJsonErrorResponse(res, http.StatusUnauthorized, "invalid code")
+        return
+    }
+
+    // Otherwise a leaked mfa token would open another session with every code that comes after it
+    params := database.UseMfaChallengeParams { ID: challenge.ID, UserID: user.ID, ExpiresAt: dbTime(challenge.ExpiresAt) }
+    used, err := cfg.Db.UseMfaChallenge(req.Context(), params)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to log in")
+        fmt.Printf("Failed to use mfa challenge of user %v: %v\n", user.ID, err)
+        return
+    }
+    if used == 0 {
+        SendJsonErrorResponse(res, http.StatusUnauthorized, "invalid or expired mfa token")
+        return
+    }
+    if err := cfg.Db.DeleteExpiredMfaChallenges(req.Context(), dbNow()); err != nil {
+        fmt.Printf("Failed to delete expired mfa challenges: %v\n", err)
+    }
+
+    cfg.clearLoginFailures(req.Context(), throttleKeys)
+    cfg.sendLoginResponse(res, req, user)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "time"
+    "fmt"
+    "regexp"
+    "strconv"
+    "database/sql"
+
+    "github.com/google/uuid"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+)
+
+const NOTIFICATION_MENTION string = "mention"
+const NOTIFICATION_REPLY string = "reply"
+const NOTIFICATION_LIKE string = "like"
+
+// Same rules as hashtags, an @ in the middle of a word (like an email address) isn't a mention
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@])@([A-Za-z0-9_]+)`)
+
+// Pull the distinct, normalized handles mentioned in a chirp body. Anything that can't be a valid handle
+// is skipped.
+func ExtractMentions(body string) []string {
+    var handles []string
+    seen := make(map[string]bool)
+    for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+        handle, err := NormalizeHandle(match[1])
+        if err != nil || !handle.Valid || seen[handle.String] { continue }
+        seen[handle.String] = true
+        handles = append(handles, handle.String)
+    }
+    return handles
+}
+
+// Notify a user about something another user did. Nobody is notified about their own activity.
+func (cfg *ApiConfig) Notify(ctx context.Context, recipientId, actorId uuid.UUID, kind string, chirpId uuid.UUID) error {
+    if recipientId == actorId { return nil }
+    params := database.CreateNotificationParams {
+        UserID: recipientId,
+        ActorID: actorId,
+        Kind: kind,
+        ChirpID: chirpId,
+    }
+    return cfg.Db.CreateNotification(ctx, params)
+}
+
+// Record the users a chirp mentions and notify the ones it didn't already mention. Mentions that an edit
+// removed are dropped, handles that don't belong to anyone are ignored.
+func (cfg *ApiConfig) RecordMentions(ctx context.Context, chirp database.Chirp) error {
+    var mentioned []database.User
+    if handles := ExtractMentions(chirp.Body); len(handles) > 0 {
+        var err error
+        mentioned, err = cfg.Db.GetUsersByHandles(ctx, handles)
+        if err != nil { return err }
+    }
+
+    mentionedIds := make([]uuid.UUID, 0, len(mentioned))
+    for _, user := range mentioned { mentionedIds = append(mentionedIds, user.ID) }
+    removeParams := database.RemoveStaleMentionsParams { ChirpID: chirp.ID, UserIds: mentionedIds }
+    if err := cfg.Db.RemoveStaleMentions(ctx, removeParams); err != nil { return err }
+
+    for _, userId := range mentionedIds {
+        added, err := cfg.Db.AddMention(ctx, database.AddMentionParams { ChirpID: chirp.ID, UserID: userId })
+        if err != nil { return err }
+        if added == 0 { continue }
+        if err := cfg.Notify(ctx, userId, chirp.UserID, NOTIFICATION_MENTION, chirp.ID); err != nil { return err }
+    }
+
+    return nil
+}
+
+type ResponseNotification struct {
+    ID          uuid.UUID   `json:"id"`
+    CreatedAt   time.Time   `json:"created_at"`
+    Kind        string      `json:"kind"`
+    ActorID     uuid.UUID   `json:"actor_id"`
+    // The chirp that mentioned the user, the reply, or the chirp that was liked
+    ChirpID     uuid.UUID   `json:"chirp_id"`
+    Read        bool        `json:"read"`
+    ReadAt      *time.Time  `json:"read_at"`
+}
+
+func MakeResponseNotification(notification database.Notification) ResponseNotification {
+    responseNotification := ResponseNotification {
+        ID: notification.ID,
+        CreatedAt: notification.CreatedAt,
+        Kind: notification.Kind,
+        ActorID: notification.ActorID,
+        ChirpID: notification.ChirpID,
+        Read: notification.ReadAt.Valid,
+    }
+    if notification.ReadAt.Valid { responseNotification.ReadAt = &notification.ReadAt.Time }
+    return responseNotification
+}
+
+type ResponseNotificationPage struct {
+    Notifications   []ResponseNotification  `json:"notifications"`
+    UnreadCount     int64                   `json:"unread_count"`
+    NextCursor      *string                 `json:"next_cursor"`
+}
+
+// The authenticated user's notifications, newest first. ?unread=true leaves out the ones already read.
+func (cfg *ApiConfig) HandleGetNotifications(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    queryValues := req.URL.Query()
+    unreadOnly := false
+    if unreadStr := queryValues.Get("unread"); unreadStr != "" {
+        var err error
+        unreadOnly, err = strconv.ParseBool(unreadStr)
+        if err != nil {
+            SendJsonErrorResponse(res, http.StatusBadRequest, "unread must be true or false")
+            return
+        }
+    }
+    limit, cursor, err := GetPageParameters(queryValues)
+    // Like follow listings, notifications only ever hand out cursors to the next page
+    if err == nil && cursor != nil && cursor.Backward { err = fmt.Errorf("invalid cursor") }
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    params := database.GetNotificationsParams {
+        UserID: userId,
+        UnreadOnly: unreadOnly,
+        PageLimit: int32(limit + 1),
+    }
+    if cursor != nil {
+        params.BeforeCreatedAt = sql.NullTime { Time: cursor.CreatedAt, Valid: true }
+        params.BeforeID = uuid.NullUUID { UUID: cursor.ID, Valid: true }
+    }
+    notifications, err := cfg.Db.GetNotifications(req.Context(), params)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get notifications")
+        fmt.Printf("Failed to retrieve notifications of user %v: %v\n", userId, err)
+        return
+    }
+    unreadCount, err := cfg.Db.CountUnreadNotifications(req.Context(), userId)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get notifications")
+        fmt.Printf("Failed to count unread notifications of user %v: %v\n", userId, err)
+        return
+    }
+
+    notifications, nextCursor, _ := PaginateResults(
+        notifications,
+        limit,
+        cursor,
+        func(notification database.Notification) (time.Time, uuid.UUID) { return notification.CreatedAt, notification.ID },
+    )
+    responseNotifications := make([]ResponseNotification, 0, len(notifications))
+    for _, notification := range notifications {
+        responseNotifications = append(responseNotifications, MakeResponseNotification(notification))
+    }
+
+    SetPaginationLinks(res, req, nextCursor, nil)
+    SendJsonResponse(res, http.StatusOK, ResponseNotificationPage {
+        Notifications: responseNotifications,
+        UnreadCount: unreadCount,
+        NextCursor: nextCursor,
+    })
+}
+
+// Mark specific notifications as read. Ids that don't belong to the user or were already read are ignored.
+func (cfg *ApiConfig) HandleMarkNotificationsRead(res http.ResponseWriter, req *http.Request) {
+    type RequestParameters struct {
+        Ids []uuid.UUID `json:"ids"`
+    }
+    var reqParams RequestParameters
+    if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+
+    userId := GetAuthenticatedUserId(req)
+
+    if len(reqParams.Ids) == 0 || len(reqParams.Ids) > MAX_PAGE_LIMIT {
+        SendJsonErrorResponse(res, http.StatusBadRequest, fmt.Sprintf("ids must have between 1 and %v notification ids", MAX_PAGE_LIMIT))
+        return
+    }
+
+    params := database.MarkNotificationsReadParams { UserID: userId, Ids: reqParams.Ids }
+    if _, err := cfg.Db.MarkNotificationsRead(req.Context(), params); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to mark notifications read")
+        fmt.Printf("Failed to mark notifications read for user %v: %v\n", userId, err)
+        return
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *ApiConfig) HandleMarkAllNotificationsRead(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    if _, err := cfg.Db.MarkAllNotificationsRead(req.Context(), userId); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to mark notifications read")
+        fmt.Printf("Failed to mark all notifications read for user %v: %v\n", userId, err)
+        return
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "time"
+    "fmt"
+    "errors"
+    "strings"
+    "database/sql"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/auth"
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+    "github.com/vedaRadev/chirpy-boot.dev/internal/mail"
+)
+
+const PASSWORD_RESET_EXPIRY time.Duration = time.Hour
+
+// Email the user a one-time token they can use to choose a new password
+func (cfg *ApiConfig) SendPasswordResetEmail(ctx context.Context, user database.User) error {
+    token, err := auth.MakeRandomToken()
+    if err != nil { return err }
+    params := database.CreatePasswordResetTokenParams {
+        TokenHash: auth.HashToken(token),
+        UserID: user.ID,
+        ExpiresAt: dbNow().Add(PASSWORD_RESET_EXPIRY),
+    }
+    if err := cfg.Db.CreatePasswordResetToken(ctx, params); err != nil { return err }
+
+    message := mail.Message {
+        To: user.Email,
+        Subject: "Reset your Chirpy password",
+        Body: fmt.Sprintf(
+            "Someone asked to reset the password of your Chirpy account. Use this code within %v to choose a " +
+            "new password:\n\n%v\n\nIf it wasn't you, you can ignore this email and your password won't change.\n",
+            PASSWORD_RESET_EXPIRY,
+            token,
+        ),
+    }
+    return cfg.Mailer.Send(message)
+}
+
+// Responds with 202 whether or not the email has an account so it can't be used to find out which do. Only
+// a few emails can be asked for per address and per email before responding with 429.
+func (cfg *ApiConfig) HandleForgotPassword(res http.ResponseWriter, req *http.Request) {
+    type RequestParameters struct {
+        Email string `json:"email"`
+    }
+    var reqParams RequestParameters
+    if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+
+    // Keyed by the email that was asked for like logins are, so this doesn't give away which have accounts
+    throttleKeys := getPasswordResetThrottleKeys(reqParams.Email, req)
+    if !cfg.beginThrottledAttempt(res, req, throttleKeys, "too many password reset requests, try again later") { return }
+
+    user, err := cfg.Db.GetUserByEmail(req.Context(), strings.TrimSpace(reqParams.Email))
+    if err == nil {
+        // Sent in the background so the response doesn't take noticeably longer for existing accounts
+        go func() {
+            if err := cfg.SendPasswordResetEmail(context.Background(), user); err != nil {
+                fmt.Printf("Failed to send password reset email to user %v: %v\n", user.ID, err)
+            }
+        }()
+    }
+
+    res.WriteHeader(http.StatusAccepted)
+}
+
+// Set a new password using a token from a reset email. Every session the user had is logged out.
+func (cfg *ApiConfig) HandleResetPassword(res http.ResponseWriter, req *http.Request) {
+    type RequestParameters struct {
+        Token string `json:"token"`
+        Password string `json:"password"`
+    }
+    var reqParams RequestParameters
+    if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+    if reqParams.Password == "" {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "password can't be empty")
+        return
+    }
+
+    errInvalidToken := errors.New("reset token is invalid or expired")
+    // Checked before hashing so bad tokens can't be used to make the server hash as many passwords as it's sent
+    tokenHash := auth.HashToken(reqParams.Token)
+    resetToken, err := cfg.Db.GetPasswordResetToken(req.Context(), tokenHash)
+    if errors.Is(err, sql.ErrNoRows) || (err == nil && time.Now().After(resetToken.ExpiresAt)) {
+        SendJsonErrorResponse(res, http.StatusBadRequest, errInvalidToken.Error())
+        return
+    }
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to reset password")
+        fmt.Printf("Failed to get password reset token: %v\n", err)
+        return
+    }
+
+    hashedPassword, err := cfg.Passwords.Hash(reqParams.Password)
+    if err != nil {
+        sendPasswordHashError(res, err)
+        return
+    }
+
+    // The token is still used up in the transaction in case another request used it in the meantime
+    err = cfg.WithTx(req.Context(), func(queries *database.Queries) error {
+        resetToken, err := queries.UsePasswordResetToken(req.Context(), tokenHash)
+        if errors.Is(err, sql.ErrNoRows) { return errInvalidToken }
+        if err != nil { return err }
+        if time.Now().After(resetToken.ExpiresAt) { return errInvalidToken }
+
+        params := database.UpdateUserParams {
+            ID: resetToken.UserID,
+            HashedPassword: sql.NullString { String: hashedPassword, Valid: true },
+        }
+        if _, err := queries.UpdateUser(req.Context(), params); err != nil { return err }
+        if err := queries.InvalidatePasswordResetTokens(req.Context(), resetToken.UserID); err != nil { return err }
+        return queries.RevokeUserRefreshTokens(req.Context(), resetToken.UserID)
+    })
+    if errors.Is(err, errInvalidToken) {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to reset password")
+        fmt.Printf("Failed to reset password: %v\n", err)
+        return
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "fmt"
+
+    "github.com/google/uuid"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+)
+
+// Get a chirp, following plain rechirps through to the chirp they re-shared. Replies, quotes, likes
+// and rechirps of a rechirp all apply to the original.
+func (cfg *ApiConfig) getOriginalChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+    chirp, err := cfg.Db.GetChirp(ctx, id)
+    if err != nil { return chirp, err }
+    if chirp.RechirpOf.Valid { return cfg.Db.GetChirp(ctx, chirp.RechirpOf.UUID) }
+    return chirp, nil
+}
+
+// Attach the rechirped or quoted chirp to each chirp response that has one. Returns the attached
+// originals so the caller can fill in the rest of their details.
+func (cfg *ApiConfig) PopulateChirpOriginals(ctx context.Context, chirps ...*ResponseChirp) ([]*ResponseChirp, error) {
+    var originalIds []uuid.UUID
+    for _, chirp := range chirps {
+        if chirp.RechirpOf != nil { originalIds = append(originalIds, *chirp.RechirpOf) }
+        if chirp.QuoteOf != nil { originalIds = append(originalIds, *chirp.QuoteOf) }
+    }
+    if len(originalIds) == 0 { return nil, nil }
+
+    originals, err := cfg.Db.GetChirpsByIds(ctx, originalIds)
+    if err != nil { return nil, err }
+    originalsById := make(map[uuid.UUID]database.Chirp, len(originals))
+    for _, original := range originals { originalsById[original.ID] = original }
+
+    var attached []*ResponseChirp
+    for _, chirp := range chirps {
+        originalId := chirp.RechirpOf
+        if originalId == nil { originalId = chirp.QuoteOf }
+        if originalId == nil { continue }
+        original, ok := originalsById[*originalId]
+        if !ok { continue }
+
+        responseOriginal := MakeResponseChirp(original)
+        chirp.Original = &responseOriginal
+        attached = append(attached, chirp.Original)
+    }
+
+    return attached, nil
+}
+
+func (cfg *ApiConfig) HandleRechirp(res http.ResponseWriter, req *http.Request) {
+    idStr := req.PathValue("id")
+    idUuid, err := uuid.Parse(idStr)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid uuid")
+        return
+    }
+
+    userId := GetAuthenticatedUserId(req)
+    if err, errCode := cfg.checkCanChirp(req.Context(), userId); err != nil {
+        SendJsonErrorResponse(res, errCode, err.Error())
+        return
+    }
+
+    original, err := cfg.getOriginalChirp(req.Context(), idUuid)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "chirp not found")
+        return
+    }
+
+    params := database.CreateChirpParams {
+        UserID: userId,
+        RechirpOf: uuid.NullUUID { UUID: original.ID, Valid: true },
+    }
+    chirp, err := cfg.Db.CreateChirp(req.Context(), params)
+    if err != nil {
+        if _, ok := GetUniqueViolation(err); ok {
+            SendJsonErrorResponse(res, http.StatusConflict, "chirp already rechirped")
+            return
+        }
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to rechirp")
+        fmt.Printf("Failed to rechirp chirp %v for user %v: %v\n", original.ID, userId, err)
+        return
+    }
+
+    responseChirp := MakeResponseChirp(chirp)
+    viewerId := uuid.NullUUID { UUID: userId, Valid: true }
+    if err := cfg.PopulateChirps(req.Context(), viewerId, &responseChirp); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get chirp details")
+        return
+    }
+    SendJsonResponse(res, http.StatusCreated, responseChirp)
+}
+
+func (cfg *ApiConfig) HandleUndoRechirp(res http.ResponseWriter, req *http.Request) {
+    idStr := req.PathValue("id")
+    idUuid, err := uuid.Parse(idStr)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid uuid")
+        return
+    }
+
+    userId := GetAuthenticatedUserId(req)
+
+    original, err := cfg.getOriginalChirp(req.Context(), idUuid)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "chirp not found")
+        return
+    }
+
+    params := database.DeleteRechirpParams {
+        UserID: userId,
+        RechirpOf: uuid.NullUUID { UUID: original.ID, Valid: true },
+    }
+    if _, err := cfg.Db.DeleteRechirp(req.Context(), params); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to undo rechirp")
+        return
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
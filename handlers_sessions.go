@@ -0,0 +1,97 @@
+package main
+
+import (
+    "net"
+    "net/http"
+    "time"
+    "fmt"
+
+    "github.com/google/uuid"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+)
+
+// The address the request came from. The server isn't set up behind a trusted proxy, so headers like
+// X-Forwarded-For are ignored since any client can set them.
+func GetClientIp(req *http.Request) string {
+    host, _, err := net.SplitHostPort(req.RemoteAddr)
+    if err != nil { return req.RemoteAddr }
+    return host
+}
+
+// A logged in device. Its id is the id of the refresh token family, which stays the same across refreshes.
+type ResponseSession struct {
+    ID          uuid.UUID   `json:"id"`
+    CreatedAt   time.Time   `json:"created_at"`
+    LastUsedAt  time.Time   `json:"last_used_at"`
+    ExpiresAt   time.Time   `json:"expires_at"`
+    UserAgent   string      `json:"user_agent"`
+    Ip          string      `json:"ip"`
+}
+
+func MakeResponseSession(refreshToken database.RefreshToken) ResponseSession {
+    return ResponseSession {
+        ID: refreshToken.FamilyID,
+        CreatedAt: refreshToken.SessionCreatedAt,
+        // Each refresh replaces the token, so the newest token was created the last time the session was used
+        LastUsedAt: refreshToken.CreatedAt,
+        ExpiresAt: refreshToken.ExpiresAt,
+        UserAgent: refreshToken.UserAgent,
+        Ip: refreshToken.Ip,
+    }
+}
+
+// The authenticated user's active sessions, most recently used first
+func (cfg *ApiConfig) HandleGetSessions(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    params := database.GetUserSessionsParams { UserID: userId, ExpiresAt: dbNow() }
+    refreshTokens, err := cfg.Db.GetUserSessions(req.Context(), params)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get sessions")
+        fmt.Printf("Failed to retrieve sessions of user %v: %v\n", userId, err)
+        return
+    }
+
+    sessions := make([]ResponseSession, 0, len(refreshTokens))
+    for _, refreshToken := range refreshTokens { sessions = append(sessions, MakeResponseSession(refreshToken)) }
+    SendJsonResponse(res, http.StatusOK, sessions)
+}
+
+// Log a device out. Access tokens it already has keep working until they expire, but it can't get new ones.
+func (cfg *ApiConfig) HandleRevokeSession(res http.ResponseWriter, req *http.Request) {
+    idUuid, err := uuid.Parse(req.PathValue("id"))
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid uuid")
+        return
+    }
+
+    userId := GetAuthenticatedUserId(req)
+
+    params := database.RevokeUserSessionParams { UserID: userId, FamilyID: idUuid }
+    revoked, err := cfg.Db.RevokeUserSession(req.Context(), params)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to revoke session")
+        fmt.Printf("Failed to revoke session %v of user %v: %v\n", idUuid, userId, err)
+        return
+    }
+    if revoked == 0 {
+        SendJsonErrorResponse(res, http.StatusNotFound, "session not found")
+        return
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
+
+// Log out everywhere, including the device making the request
+func (cfg *ApiConfig) HandleRevokeAllSessions(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    if err := cfg.Db.RevokeUserRefreshTokens(req.Context(), userId); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to revoke sessions")
+        fmt.Printf("Failed to revoke all sessions of user %v: %v\n", userId, err)
+        return
+    }
+
+    res.WriteHeader(http.StatusNoContent)
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+    "context"
     "net/http"
     "time"
     "fmt"
+    "regexp"
+    "strings"
+    "database/sql"
+    "errors"
+    "net/url"
+    "unicode/utf8"
 
     "github.com/google/uuid"
 
@@ -16,6 +23,12 @@ type ResponseUser struct {
     CreatedAt       time.Time   `json:"created_at"`
     UpdatedAt       time.Time   `json:"updated_at"`
     Email           string      `json:"email"`
+    Handle          *string     `json:"handle"`
+    DisplayName     string      `json:"display_name"`
+    Bio             string      `json:"bio"`
+    Website         string      `json:"website"`
+    EmailVerified   bool        `json:"email_verified"`
+    MfaEnabled      bool        `json:"mfa_enabled"`
     IsChirpyRed     bool        `json:"is_chirpy_red"`
     // TODO move Token and RefreshToken to their own type and embed responseuser OR make them
     // nullable strings (*string)?
@@ -23,10 +36,128 @@ type ResponseUser struct {
     RefreshToken    string      `json:"refresh_token"`
 }
 
+func MakeResponseUser(user database.User) ResponseUser {
+    responseUser := ResponseUser {
+        ID: user.ID,
+        CreatedAt: user.CreatedAt,
+        UpdatedAt: user.UpdatedAt,
+        Email: user.Email,
+        DisplayName: user.DisplayName,
+        Bio: user.Bio,
+        Website: user.Website,
+        EmailVerified: user.EmailVerifiedAt.Valid,
+        MfaEnabled: user.TotpEnabledAt.Valid,
+        IsChirpyRed: user.IsChirpyRed,
+    }
+    if user.Handle.Valid { responseUser.Handle = &user.Handle.String }
+    return responseUser
+}
+
+// What anyone can see about a user. Never add private fields like the email here.
+type ResponsePublicUser struct {
+    ID              uuid.UUID   `json:"id"`
+    CreatedAt       time.Time   `json:"created_at"`
+    Handle          *string     `json:"handle"`
+    DisplayName     string      `json:"display_name"`
+    Bio             string      `json:"bio"`
+    Website         string      `json:"website"`
+    IsChirpyRed     bool        `json:"is_chirpy_red"`
+}
+
+func MakeResponsePublicUser(user database.User) ResponsePublicUser {
+    responseUser := ResponsePublicUser {
+        ID: user.ID,
+        CreatedAt: user.CreatedAt,
+        DisplayName: user.DisplayName,
+        Bio: user.Bio,
+        Website: user.Website,
+        IsChirpyRed: user.IsChirpyRed,
+    }
+    if user.Handle.Valid { responseUser.Handle = &user.Handle.String }
+    return responseUser
+}
+
+const MAX_DISPLAY_NAME_LEN int = 50
+const MAX_BIO_LEN int = 160
+const MAX_WEBSITE_LEN int = 200
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+// Handles that would be shadowed by other /api/users/... routes
+var reservedHandles = map[string]bool { "verify": true, "export": true }
+
+// Handles are case insensitive so they're always stored lowercase. A leading @ is allowed, and an empty
+// handle means none was given.
+func NormalizeHandle(handle string) (sql.NullString, error) {
+    handle = strings.ToLower(strings.TrimPrefix(handle, "@"))
+    if handle == "" { return sql.NullString {}, nil }
+    if !handlePattern.MatchString(handle) {
+        return sql.NullString {}, fmt.Errorf("handle must be 3 to 30 letters, numbers or underscores")
+    }
+    if reservedHandles[handle] { return sql.NullString {}, fmt.Errorf("handle is reserved") }
+    return sql.NullString { String: handle, Valid: true }, nil
+}
+
+// Validate an optional profile field from a request. A missing field stays NULL so the current value is
+// kept, an empty one clears it.
+func getProfileField(value *string, name string, maxLen int) (sql.NullString, error) {
+    if value == nil { return sql.NullString {}, nil }
+    trimmed := strings.TrimSpace(*value)
+    if utf8.RuneCountInString(trimmed) > maxLen {
+        return sql.NullString {}, fmt.Errorf("%v must be at most %v characters", name, maxLen)
+    }
+    return sql.NullString { String: trimmed, Valid: true }, nil
+}
+
+// Websites are shown as links so only absolute http(s) urls are allowed
+func getWebsiteField(value *string) (sql.NullString, error) {
+    website, err := getProfileField(value, "website", MAX_WEBSITE_LEN)
+    if err != nil || website.String == "" { return website, err }
+    parsed, err := url.Parse(website.String)
+    if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+        return sql.NullString {}, fmt.Errorf("website must be an http or https url")
+    }
+    return website, nil
+}
+
+// Validate the optional handle and profile fields accepted by both kinds of user update
+func getProfileUpdate(handle string, displayName, bio, website *string) (database.UpdateUserParams, error) {
+    var params database.UpdateUserParams
+    var err error
+    if params.Handle, err = NormalizeHandle(handle); err != nil { return params, err }
+    if params.DisplayName, err = getProfileField(displayName, "display_name", MAX_DISPLAY_NAME_LEN); err != nil { return params, err }
+    if params.Bio, err = getProfileField(bio, "bio", MAX_BIO_LEN); err != nil { return params, err }
+    if params.Website, err = getWebsiteField(website); err != nil { return params, err }
+    return params, nil
+}
+
+// Turn a failure to save a user into a response, telling the client which unique field was taken
+func sendUserSaveError(res http.ResponseWriter, err error, message string) {
+    if constraint, ok := GetUniqueViolation(err); ok {
+        switch constraint {
+        case "users_handle_key": SendJsonErrorResponse(res, http.StatusConflict, "handle already taken")
+        case "users_email_key": SendJsonErrorResponse(res, http.StatusConflict, "email already in use")
+        default: SendJsonErrorResponse(res, http.StatusConflict, message)
+        }
+        return
+    }
+    SendJsonErrorResponse(res, http.StatusInternalServerError, message)
+}
+
+// The password itself can be the problem, e.g. when bcrypt is used and it's too long
+func sendPasswordHashError(res http.ResponseWriter, err error) {
+    if errors.Is(err, auth.ErrPasswordTooLong) {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+    SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to encrypt password")
+}
+
 func (cfg *ApiConfig) HandleCreateUser(res http.ResponseWriter, req *http.Request) {
     type RequestParameters struct  {
         Email string `json:"email"`
         Password string `json:"password"`
+        Handle string `json:"handle"`
     }
     var reqParams RequestParameters
     if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
@@ -34,36 +165,139 @@ func (cfg *ApiConfig) HandleCreateUser(res http.ResponseWriter, req *http.Reques
         return
     }
 
-    hashedPassword, err := auth.HashPassword(reqParams.Password)
+    handle, err := NormalizeHandle(reqParams.Handle)
     if err != nil {
-        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to encrypt password")
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+
+    hashedPassword, err := cfg.Passwords.Hash(reqParams.Password)
+    if err != nil {
+        sendPasswordHashError(res, err)
         return
     }
 
     params := database.CreateUserParams {
         Email: reqParams.Email,
         HashedPassword: hashedPassword,
+        Handle: handle,
     }
     user, err := cfg.Db.CreateUser(req.Context(), params)
     if err != nil {
-        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to create user")
+        sendUserSaveError(res, err, "failed to create user")
         fmt.Printf("Failed to create user %v: %v\n", reqParams.Email, err.Error())
         return
     }
-    responseUser := ResponseUser {
-        ID: user.ID,
-        CreatedAt: user.CreatedAt,
-        UpdatedAt: user.UpdatedAt,
-        Email: user.Email,
-        IsChirpyRed: user.IsChirpyRed,
+    // The account exists either way, the user can ask for another verification email later
+    if err := cfg.SendVerificationEmail(req.Context(), user); err != nil {
+        fmt.Printf("Failed to send verification email to user %v: %v\n", user.ID, err)
     }
-    SendJsonResponse(res, http.StatusCreated, responseUser)
+    SendJsonResponse(res, http.StatusCreated, MakeResponseUser(user))
+}
+
+// Update a user and start verifying their new email if it changed. Changing the password logs out every
+// session, like resetting it does.
+func (cfg *ApiConfig) updateUser(ctx context.Context, params database.UpdateUserParams) (database.User, error) {
+    previous, err := cfg.Db.GetUser(ctx, params.ID)
+    if err != nil { return previous, err }
+    var user database.User
+    err = cfg.WithTx(ctx, func(queries *database.Queries) error {
+        user, err = queries.UpdateUser(ctx, params)
+        if err != nil { return err }
+        if !params.HashedPassword.Valid { return nil }
+        return queries.RevokeUserRefreshTokens(ctx, user.ID)
+    })
+    if err != nil { return user, err }
+
+    if user.Email != previous.Email {
+        if err := cfg.SendVerificationEmail(ctx, user); err != nil {
+            fmt.Printf("Failed to send verification email to user %v: %v\n", user.ID, err)
+        }
+    }
+    return user, nil
+}
+
+// The fields of a user that can be changed. Nil fields are left as they are.
+type userUpdateParameters struct {
+    Email *string `json:"email"`
+    Password *string `json:"password"`
+    // Needed to change the email or password, so a stolen access token can't be used to take over the
+    // account, either directly or by pointing the email somewhere else and resetting the password
+    CurrentPassword string `json:"current_password"`
+    Handle string `json:"handle"`
+    DisplayName *string `json:"display_name"`
+    Bio *string `json:"bio"`
+    Website *string `json:"website"`
 }
 
+func (cfg *ApiConfig) saveUserUpdate(res http.ResponseWriter, req *http.Request, reqParams userUpdateParameters) {
+    userId := GetAuthenticatedUserId(req)
+
+    params, err := getProfileUpdate(reqParams.Handle, reqParams.DisplayName, reqParams.Bio, reqParams.Website)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, err.Error())
+        return
+    }
+    params.ID = userId
+
+    if reqParams.Email != nil {
+        email := strings.TrimSpace(*reqParams.Email)
+        if email == "" {
+            SendJsonErrorResponse(res, http.StatusBadRequest, "email can't be empty")
+            return
+        }
+        params.Email = sql.NullString { String: email, Valid: true }
+    }
+    if reqParams.Password != nil && *reqParams.Password == "" {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "password can't be empty")
+        return
+    }
+
+    if reqParams.Email != nil || reqParams.Password != nil {
+        user, err := cfg.Db.GetUser(req.Context(), userId)
+        if err != nil {
+            SendJsonErrorResponse(res, http.StatusNotFound, "user not found")
+            return
+        }
+        if !cfg.checkReenteredPassword(res, req, user, reqParams.CurrentPassword, "incorrect current password") { return }
+        // PUT always sends the password, so sending the one the user already has mustn't count as changing it
+        // and log out every session
+        if reqParams.Password != nil {
+            if _, err := cfg.Passwords.Check(*reqParams.Password, user.HashedPassword); err == nil { reqParams.Password = nil }
+        }
+    }
+
+    if reqParams.Password != nil {
+        hashedPassword, err := cfg.Passwords.Hash(*reqParams.Password)
+        if err != nil {
+            sendPasswordHashError(res, err)
+            return
+        }
+        params.HashedPassword = sql.NullString { String: hashedPassword, Valid: true }
+    }
+
+    user, err := cfg.updateUser(req.Context(), params)
+    if err != nil {
+        sendUserSaveError(res, err, "failed to update user")
+        fmt.Printf("Failed to update user %v: %v\n", userId, err)
+        return
+    }
+    SendJsonResponse(res, http.StatusOK, MakeResponseUser(user))
+}
+
+// Replace the user's email and password. Both are always sent, so current_password is always required,
+// just like changing either with HandlePatchUser. Sending the password the user already has doesn't log out
+// their other sessions. The handle and profile fields are optional here.
 func (cfg *ApiConfig) HandleUpdateUser(res http.ResponseWriter, req *http.Request) {
     type RequestParameters struct  {
         Email string `json:"email"`
         Password string `json:"password"`
+        CurrentPassword string `json:"current_password"`
+        // Optional, the current handle and profile are kept for anything left out
+        Handle string `json:"handle"`
+        DisplayName *string `json:"display_name"`
+        Bio *string `json:"bio"`
+        Website *string `json:"website"`
     }
     var reqParams RequestParameters
     if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
@@ -71,36 +305,44 @@ func (cfg *ApiConfig) HandleUpdateUser(res http.ResponseWriter, req *http.Reques
         return
     }
 
-    userId, err, errCode := GetAuthenticatedUserId(req.Header, cfg.Secret)
-    if err != nil {
+    cfg.saveUserUpdate(res, req, userUpdateParameters {
+        Email: &reqParams.Email,
+        Password: &reqParams.Password,
+        CurrentPassword: reqParams.CurrentPassword,
+        Handle: reqParams.Handle,
+        DisplayName: reqParams.DisplayName,
+        Bio: reqParams.Bio,
+        Website: reqParams.Website,
+    })
+}
+
+// Change only the fields present in the request. Changing the email or password requires the current
+// password as well.
+func (cfg *ApiConfig) HandlePatchUser(res http.ResponseWriter, req *http.Request) {
+    var reqParams userUpdateParameters
+    if err, errCode := DecodeRequestBodyParameters(&reqParams, res, req); err != nil {
         SendJsonErrorResponse(res, errCode, err.Error())
         return
     }
 
-    hashedPassword, err := auth.HashPassword(reqParams.Password)
-    if err != nil {
-        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to encrypt password")
+    cfg.saveUserUpdate(res, req, reqParams)
+}
+
+// Look up a user's public profile by their handle, with or without the leading @
+func (cfg *ApiConfig) HandleGetUserProfile(res http.ResponseWriter, req *http.Request) {
+    handle, err := NormalizeHandle(req.PathValue("handle"))
+    if err != nil || !handle.Valid {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid handle")
         return
     }
 
-    params := database.UpdateUserParams {
-        ID: userId,
-        Email: reqParams.Email,
-        HashedPassword: hashedPassword,
-    }
-    user, err := cfg.Db.UpdateUser(req.Context(), params)
+    user, err := cfg.Db.GetUserByHandle(req.Context(), handle)
     if err != nil {
-        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to update user")
+        SendJsonErrorResponse(res, http.StatusNotFound, "user not found")
         return
     }
-    responseUser := ResponseUser {
-        ID: user.ID,
-        CreatedAt: user.CreatedAt,
-        UpdatedAt: user.UpdatedAt,
-        Email: user.Email,
-        IsChirpyRed: user.IsChirpyRed,
-    }
-    SendJsonResponse(res, http.StatusOK, responseUser)
+
+    SendJsonResponse(res, http.StatusOK, MakeResponsePublicUser(user))
 }
 
 func (cfg *ApiConfig) HandleLogin(res http.ResponseWriter, req *http.Request) {
@@ -114,53 +356,106 @@ func (cfg *ApiConfig) HandleLogin(res http.ResponseWriter, req *http.Request) {
         return
     }
 
+    throttleKeys := getLoginThrottleKeys(reqParams.Email, req)
+    if !cfg.beginLoginAttempt(res, req, throttleKeys) { return }
+
+    // An unknown email and a wrong password have to look the same, both in the response and in how long it
+    // takes, so the login can't be used to find out which emails have accounts
     user, err := cfg.Db.GetUserByEmail(req.Context(), reqParams.Email)
+    if errors.Is(err, sql.ErrNoRows) {
+        cfg.Passwords.CheckDummy(reqParams.Password)
+    } else if err != nil {
+        cfg.forgiveLoginAttempt(req.Context(), throttleKeys)
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to log in")
+        fmt.Printf("Failed to retrieve user for login: %v\n", err)
+        return
+    }
+    var needsRehash bool
+    if err == nil { needsRehash, err = cfg.Passwords.Check(reqParams.Password, user.HashedPassword) }
+    // The attempt was already counted as a failure by beginLoginAttempt
     if err != nil {
-        SendJsonErrorResponse(res, http.StatusNotFound, "no user with the provided email exists")
+        SendJsonErrorResponse(res, http.StatusUnauthorized, "incorrect email or password")
         return
     }
+    // This is the only time the password is known, so it's when hashes from an old hasher or with old
+    // settings get replaced. The login works either way.
+    if needsRehash { cfg.rehashPassword(req.Context(), user, reqParams.Password) }
 
-    if err := auth.CheckPasswordHash(reqParams.Password, user.HashedPassword); err != nil {
-        SendJsonErrorResponse(res, http.StatusUnauthorized, "incorrect email or password")
+    // The tokens are only handed out once the second factor is checked too, see HandleLoginMfa
+    if user.TotpEnabledAt.Valid {
+        cfg.forgiveLoginAttempt(req.Context(), throttleKeys)
+        mfaToken, err := auth.MakeMfaChallengeJWT(user.ID, cfg.Keys, MFA_CHALLENGE_EXPIRY)
+        if err != nil {
+            SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to make mfa token")
+            return
+        }
+        SendJsonResponse(res, http.StatusOK, ResponseMfaChallenge { MfaRequired: true, MfaToken: mfaToken })
         return
     }
 
-    accessToken, err := auth.MakeJWT(user.ID, cfg.Secret, time.Hour)
+    cfg.clearLoginFailures(req.Context(), throttleKeys)
+    cfg.sendLoginResponse(res, req, user)
+}
+
+func (cfg *ApiConfig) rehashPassword(ctx context.Context, user database.User, password string) {
+    hashedPassword, err := cfg.Passwords.Hash(password)
     if err != nil {
-        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to make access token")
+        fmt.Printf("Failed to rehash password of user %v: %v\n", user.ID, err)
         return
     }
+    params := database.RehashUserPasswordParams { NewHash: hashedPassword, ID: user.ID, OldHash: user.HashedPassword }
+    if err := cfg.Db.RehashUserPassword(ctx, params); err != nil {
+        fmt.Printf("Failed to store rehashed password of user %v: %v\n", user.ID, err)
+    }
+}
 
-    refreshToken, err := auth.MakeRefreshToken()
+// Start a new session for a user who has proven who they are
+func (cfg *ApiConfig) sendLoginResponse(res http.ResponseWriter, req *http.Request, user database.User) {
+    accessToken, err := auth.MakeJWT(user.ID, cfg.Keys, auth.AllScopes, ACCESS_TOKEN_EXPIRY)
     if err != nil {
-        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to make refresh token")
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to make access token")
         return
     }
-    now := time.Now()
-    refreshTokenExpiry := now.Add(60 * 24 * time.Hour)
-    dbParams := database.CreateRefreshTokenParams {
-        Token: refreshToken,
+
+    // Logging in starts a new session, i.e. a new family of refresh tokens
+    session := database.CreateRefreshTokenParams {
         UserID: user.ID,
-        ExpiresAt: refreshTokenExpiry,
+        FamilyID: uuid.New(),
+        SessionCreatedAt: dbNow(),
+        UserAgent: req.UserAgent(),
+        Ip: GetClientIp(req),
     }
-    if _, err := cfg.Db.CreateRefreshToken(req.Context(), dbParams); err != nil {
-        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to add refresh token to database")
+    refreshToken, err := createRefreshToken(req.Context(), cfg.Db, session)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to make refresh token")
+        fmt.Printf("Failed to create refresh token for user %v: %v\n", user.ID, err)
         return
     }
 
-    responseUser := ResponseUser {
-        ID: user.ID,
-        CreatedAt: user.CreatedAt,
-        UpdatedAt: user.UpdatedAt,
-        Email: user.Email,
-        IsChirpyRed: user.IsChirpyRed,
-        Token: accessToken,
-        RefreshToken: refreshToken,
-    }
+    responseUser := MakeResponseUser(user)
+    responseUser.Token = accessToken
+    responseUser.RefreshToken = refreshToken
     SendJsonResponse(res, http.StatusOK, responseUser)
 }
 
-// Send a new access token given a valid (non-expired and non-revoked) refresh token
+const ACCESS_TOKEN_EXPIRY time.Duration = time.Hour
+const REFRESH_TOKEN_EXPIRY time.Duration = 60 * 24 * time.Hour
+
+// Hand out a new refresh token in the session given by params. The token itself and its expiry are
+// filled in here.
+func createRefreshToken(ctx context.Context, queries *database.Queries, params database.CreateRefreshTokenParams) (string, error) {
+    refreshToken, err := auth.MakeRefreshToken()
+    if err != nil { return "", err }
+    params.TokenHash = auth.HashToken(refreshToken)
+    params.ExpiresAt = dbNow().Add(REFRESH_TOKEN_EXPIRY)
+    if _, err := queries.CreateRefreshToken(ctx, params); err != nil { return "", err }
+    return refreshToken, nil
+}
+
+// Trade a valid (non-expired and non-revoked) refresh token for a new access token and a new refresh
+// token. The old refresh token is marked rotated, so if it ever shows up again it was most likely stolen.
+// When that happens the whole family is revoked, logging out both the thief and the real user. Tokens
+// revoked any other way, like by logging out, are just rejected.
 func (cfg *ApiConfig) HandleRefresh(res http.ResponseWriter, req *http.Request) {
     bearerToken, err := auth.GetBearerToken(req.Header)
     if err != nil {
@@ -168,34 +463,74 @@ func (cfg *ApiConfig) HandleRefresh(res http.ResponseWriter, req *http.Request)
         return
     }
 
-    refreshToken, err := cfg.Db.GetRefreshToken(req.Context(), bearerToken)
+    refreshToken, err := cfg.Db.GetRefreshToken(req.Context(), auth.HashToken(bearerToken))
     if err != nil {
         SendJsonErrorResponse(res, http.StatusUnauthorized, "refresh token does not exist")
         return
     }
-    now := time.Now()
-    if now.After(refreshToken.ExpiresAt) {
+    if refreshToken.RevokedAt.Valid {
+        if refreshToken.RotatedAt.Valid { cfg.revokeReusedRefreshTokenFamily(req.Context(), refreshToken) }
+        SendJsonErrorResponse(res, http.StatusUnauthorized, "refresh token revoked")
+        return
+    }
+    if time.Now().After(refreshToken.ExpiresAt) {
         SendJsonErrorResponse(res, http.StatusUnauthorized, "refresh token expired")
         return
     }
-    if refreshToken.RevokedAt.Valid && now.After(refreshToken.RevokedAt.Time) {
+
+    errReused := errors.New("refresh token already used")
+    var newRefreshToken string
+    err = cfg.WithTx(req.Context(), func(queries *database.Queries) error {
+        // Someone else may have used the same token since it was looked up
+        _, err := queries.RevokeActiveRefreshToken(req.Context(), refreshToken.ID)
+        if errors.Is(err, sql.ErrNoRows) { return errReused }
+        if err != nil { return err }
+        session := database.CreateRefreshTokenParams {
+            UserID: refreshToken.UserID,
+            FamilyID: refreshToken.FamilyID,
+            SessionCreatedAt: refreshToken.SessionCreatedAt,
+            UserAgent: refreshToken.UserAgent,
+            Ip: refreshToken.Ip,
+        }
+        newRefreshToken, err = createRefreshToken(req.Context(), queries, session)
+        return err
+    })
+    if errors.Is(err, errReused) {
+        // It was either rotated by another request using the same token or revoked some other way in the
+        // meantime, only the first is reuse
+        current, err := cfg.Db.GetRefreshToken(req.Context(), refreshToken.TokenHash)
+        if err == nil && current.RotatedAt.Valid { cfg.revokeReusedRefreshTokenFamily(req.Context(), current) }
         SendJsonErrorResponse(res, http.StatusUnauthorized, "refresh token revoked")
         return
     }
-
-    user, err := cfg.Db.GetUserFromRefreshToken(req.Context(), refreshToken.Token)
     if err != nil {
-        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to get user from refresh token")
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to rotate refresh token")
+        fmt.Printf("Failed to rotate refresh token of user %v: %v\n", refreshToken.UserID, err)
         return
     }
 
-    type ResponseBody struct { Token string `json:"token"` }
-    accessToken, err := auth.MakeJWT(user.ID, cfg.Secret, time.Hour)
+    accessToken, err := auth.MakeJWT(refreshToken.UserID, cfg.Keys, auth.AllScopes, ACCESS_TOKEN_EXPIRY)
     if err != nil {
         SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to create access token")
         return
     }
-    SendJsonResponse(res, http.StatusOK, ResponseBody { Token: accessToken })
+
+    type ResponseBody struct {
+        Token           string  `json:"token"`
+        RefreshToken    string  `json:"refresh_token"`
+    }
+    SendJsonResponse(res, http.StatusOK, ResponseBody { Token: accessToken, RefreshToken: newRefreshToken })
+}
+
+func (cfg *ApiConfig) revokeReusedRefreshTokenFamily(ctx context.Context, refreshToken database.RefreshToken) {
+    fmt.Printf(
+        "Rotated refresh token reused for user %v, revoking its family %v as it was likely stolen\n",
+        refreshToken.UserID,
+        refreshToken.FamilyID,
+    )
+    if err := cfg.Db.RevokeRefreshTokenFamily(ctx, refreshToken.FamilyID); err != nil {
+        fmt.Printf("Failed to revoke refresh token family %v: %v\n", refreshToken.FamilyID, err)
+    }
 }
 
 func (cfg *ApiConfig) HandleRevoke(res http.ResponseWriter, req *http.Request) {
@@ -205,7 +540,7 @@ func (cfg *ApiConfig) HandleRevoke(res http.ResponseWriter, req *http.Request) {
         return
     }
 
-    _, err = cfg.Db.RevokeRefreshToken(req.Context(), bearerToken)
+    _, err = cfg.Db.RevokeRefreshToken(req.Context(), auth.HashToken(bearerToken))
     if err != nil {
         // TODO figure out what the response status should actually be
         SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to revoke refresh token")
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/url"
+    "time"
+    "fmt"
+
+    "github.com/google/uuid"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/auth"
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+    "github.com/vedaRadev/chirpy-boot.dev/internal/mail"
+)
+
+const EMAIL_VERIFICATION_TOKEN_PURPOSE string = "email-verification"
+const EMAIL_VERIFICATION_EXPIRY time.Duration = 24 * time.Hour
+
+// Email the user a link that proves they own their current email address
+func (cfg *ApiConfig) SendVerificationEmail(ctx context.Context, user database.User) error {
+    params := database.CreateEmailVerificationTokenParams {
+        UserID: user.ID,
+        Email: user.Email,
+        ExpiresAt: dbNow().Add(EMAIL_VERIFICATION_EXPIRY),
+    }
+    verification, err := cfg.Db.CreateEmailVerificationToken(ctx, params)
+    if err != nil { return err }
+
+    token := auth.MakeSignedToken(EMAIL_VERIFICATION_TOKEN_PURPOSE, verification.ID, cfg.Secret)
+    link := cfg.BaseUrl + "/api/users/verify?token=" + url.QueryEscape(token)
+    message := mail.Message {
+        To: user.Email,
+        Subject: "Verify your Chirpy email",
+        Body: fmt.Sprintf(
+            "Confirm that this is your email address by opening the link below within %v:\n\n%v\n\n" +
+            "If you didn't sign up for Chirpy you can ignore this email.\n",
+            EMAIL_VERIFICATION_EXPIRY,
+            link,
+        ),
+    }
+    return cfg.Mailer.Send(message)
+}
+
+// With REQUIRE_VERIFIED_EMAIL set, users can't post anything until they've verified their email
+func (cfg *ApiConfig) checkCanChirp(ctx context.Context, userId uuid.UUID) (error, int) {
+    if !cfg.RequireVerifiedEmail { return nil, 0 }
+    user, err := cfg.Db.GetUser(ctx, userId)
+    if err != nil { return fmt.Errorf("user not found"), http.StatusNotFound }
+    if !user.EmailVerifiedAt.Valid { return fmt.Errorf("verify your email before chirping"), http.StatusForbidden }
+    return nil, 0
+}
+
+// Where the link in verification emails goes. Tokens can only be used once, and only while the user
+// still has the email they were sent to.
+func (cfg *ApiConfig) HandleVerifyEmail(res http.ResponseWriter, req *http.Request) {
+    token := req.URL.Query().Get("token")
+    verificationId, err := auth.ParseSignedToken(EMAIL_VERIFICATION_TOKEN_PURPOSE, token, cfg.Secret)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "invalid verification token")
+        return
+    }
+
+    verification, err := cfg.Db.UseEmailVerificationToken(req.Context(), verificationId)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "verification token does not exist or was already used")
+        return
+    }
+    if time.Now().After(verification.ExpiresAt) {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "verification token expired")
+        return
+    }
+
+    params := database.VerifyUserEmailParams { ID: verification.UserID, Email: verification.Email }
+    user, err := cfg.Db.VerifyUserEmail(req.Context(), params)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusBadRequest, "the email was changed after this verification was sent")
+        return
+    }
+
+    SendJsonResponse(res, http.StatusOK, MakeResponseUser(user))
+}
+
+func (cfg *ApiConfig) HandleResendVerificationEmail(res http.ResponseWriter, req *http.Request) {
+    userId := GetAuthenticatedUserId(req)
+
+    user, err := cfg.Db.GetUser(req.Context(), userId)
+    if err != nil {
+        SendJsonErrorResponse(res, http.StatusNotFound, "user not found")
+        return
+    }
+    if user.EmailVerifiedAt.Valid {
+        SendJsonErrorResponse(res, http.StatusConflict, "email already verified")
+        return
+    }
+
+    if err := cfg.SendVerificationEmail(req.Context(), user); err != nil {
+        SendJsonErrorResponse(res, http.StatusInternalServerError, "failed to send verification email")
+        fmt.Printf("Failed to send verification email to user %v: %v\n", user.ID, err)
+        return
+    }
+
+    res.WriteHeader(http.StatusAccepted)
+}
@@ -6,62 +6,148 @@ import (
     "fmt"
     "strings"
     "encoding/hex"
+    "encoding/base64"
     "crypto/rand"
-    "golang.org/x/crypto/bcrypt"
+    "crypto/hmac"
+    "crypto/sha256"
     "github.com/google/uuid"
     "github.com/golang-jwt/jwt/v5"
 )
 
-func HashPassword(password string) (string, error) {
-    hashed, err := bcrypt.GenerateFromPassword([]byte(password), 0)
-    if err != nil { return "", err }
-    return string(hashed), nil
+const JWT_ISSUER string = "chirpy"
+const ACCESS_TOKEN_AUDIENCE string = "chirpy-api"
+// Proves the password was right when the user still has to enter a second factor. The different audience
+// keeps it from being accepted as an access token.
+const MFA_CHALLENGE_AUDIENCE string = "chirpy-mfa"
+
+// What a token has to look like to pass ValidateJWT, on top of having a valid signature from a key in the set
+type ValidationOptions struct {
+    // Signing algorithms that are accepted. Empty accepts the algorithm of whichever key signed the token.
+    Algorithms []string
+    // Required value of the iss claim, not checked if empty
+    Issuer string
+    // Value the aud claim has to contain, not checked if empty
+    Audience string
+    // How far the exp, nbf and iat claims may be off to allow for clocks that aren't quite in sync
+    Leeway time.Duration
+    // Claims the token must have, e.g. "exp". The sub claim is always required.
+    RequiredClaims []string
+}
+
+// How the access tokens made by MakeJWT are validated
+var AccessTokenValidation = ValidationOptions {
+    Algorithms: []string { jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg() },
+    Issuer: JWT_ISSUER,
+    Audience: ACCESS_TOKEN_AUDIENCE,
+    Leeway: 30 * time.Second,
+    RequiredClaims: []string { "exp", "iat" },
 }
 
-func CheckPasswordHash(password, hash string) error {
-    return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+var MfaChallengeValidation = ValidationOptions {
+    Algorithms: AccessTokenValidation.Algorithms,
+    Issuer: JWT_ISSUER,
+    Audience: MFA_CHALLENGE_AUDIENCE,
+    Leeway: AccessTokenValidation.Leeway,
+    RequiredClaims: []string { "exp", "iat", "jti" },
 }
 
-func MakeJWT(userId uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+type tokenClaims struct {
+    jwt.RegisteredClaims
+    // Space separated, like OAuth scopes
+    Scope string `json:"scope,omitempty"`
+}
+
+func MakeJWT(userId uuid.UUID, keys *KeySet, scopes []string, expiresIn time.Duration) (string, error) {
+    return makeJWT(userId, keys, ACCESS_TOKEN_AUDIENCE, scopes, expiresIn)
+}
+
+// The token has a random id (the jti claim) so the server can remember which ones were already used
+func MakeMfaChallengeJWT(userId uuid.UUID, keys *KeySet, expiresIn time.Duration) (string, error) {
+    return makeJWT(userId, keys, MFA_CHALLENGE_AUDIENCE, nil, expiresIn)
+}
+
+func makeJWT(userId uuid.UUID, keys *KeySet, audience string, scopes []string, expiresIn time.Duration) (string, error) {
     now := time.Now()
-    claims := jwt.RegisteredClaims {
-        Issuer: "chirpy",
-        IssuedAt: jwt.NewNumericDate(now),
-        ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
-        Subject: userId.String(),
+    claims := tokenClaims {
+        RegisteredClaims: jwt.RegisteredClaims {
+            ID: uuid.NewString(),
+            Issuer: JWT_ISSUER,
+            Audience: jwt.ClaimStrings { audience },
+            IssuedAt: jwt.NewNumericDate(now),
+            ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+            Subject: userId.String(),
+        },
+        Scope: strings.Join(scopes, " "),
     }
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    signedString, err := token.SignedString([]byte(tokenSecret))
+    signedString, err := keys.sign(claims)
     if err != nil { return "", err }
     return signedString, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-    var result uuid.UUID
+// Get the user a token was issued to and the scopes it grants
+func ValidateJWT(tokenString string, keys *KeySet, options ValidationOptions) (uuid.UUID, []string, error) {
+    claims, userId, err := validateJWT(tokenString, keys, options)
+    if err != nil { return userId, nil, err }
+    scope, _ := claims["scope"].(string)
+    return userId, strings.Fields(scope), nil
+}
 
-    token, err := jwt.ParseWithClaims(
-        tokenString,
-        &jwt.RegisteredClaims {},
-        func (token *jwt.Token) (any, error) { return []byte(tokenSecret), nil },
-    )
-    if err != nil { return result, err }
+type MfaChallenge struct {
+    ID uuid.UUID
+    UserID uuid.UUID
+    ExpiresAt time.Time
+}
 
-    id, err := token.Claims.GetSubject()
-    if err != nil { return result, err }
+// Validate a token from MakeMfaChallengeJWT. The caller is responsible for only accepting each challenge once.
+func ValidateMfaChallengeJWT(tokenString string, keys *KeySet) (MfaChallenge, error) {
+    var result MfaChallenge
 
-    result, err = uuid.Parse(id)
+    claims, userId, err := validateJWT(tokenString, keys, MfaChallengeValidation)
     if err != nil { return result, err }
+    jti, _ := claims["jti"].(string)
+    challengeId, err := uuid.Parse(jti)
+    if err != nil { return result, fmt.Errorf("invalid jti claim") }
+    expiresAt, err := claims.GetExpirationTime()
+    if err != nil || expiresAt == nil { return result, fmt.Errorf("invalid exp claim") }
 
+    result.ID = challengeId
+    result.UserID = userId
+    result.ExpiresAt = expiresAt.Time
     return result, nil
 }
 
+func validateJWT(tokenString string, keys *KeySet, options ValidationOptions) (jwt.MapClaims, uuid.UUID, error) {
+    var result uuid.UUID
+
+    // The parser only checks exp, nbf and iat when they're present
+    parserOptions := []jwt.ParserOption { jwt.WithLeeway(options.Leeway), jwt.WithIssuedAt() }
+    if len(options.Algorithms) > 0 { parserOptions = append(parserOptions, jwt.WithValidMethods(options.Algorithms)) }
+    if options.Issuer != "" { parserOptions = append(parserOptions, jwt.WithIssuer(options.Issuer)) }
+    if options.Audience != "" { parserOptions = append(parserOptions, jwt.WithAudience(options.Audience)) }
+
+    claims := jwt.MapClaims {}
+    _, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc, parserOptions...)
+    if err != nil { return nil, result, err }
+
+    for _, name := range options.RequiredClaims {
+        if _, ok := claims[name]; !ok { return nil, result, fmt.Errorf("token is missing the %v claim", name) }
+    }
+
+    id, err := claims.GetSubject()
+    if err != nil { return nil, result, err }
+
+    result, err = uuid.Parse(id)
+    if err != nil { return nil, result, err }
+
+    return claims, result, nil
+}
+
 func getAuthHeaderValue(header http.Header, fieldName string) (string, error) {
     authHeader := header.Get("Authorization")
     if authHeader == "" { return "", fmt.Errorf("authorization header not found") }
-    if !strings.Contains(authHeader, fieldName) { return "", fmt.Errorf("invalid auth header format") }
-
     fields := strings.Fields(authHeader)
-    if len(fields) != 2 { return "", fmt.Errorf("invalid auth header format") }
+    // Auth schemes are case insensitive (RFC 7235)
+    if len(fields) != 2 || !strings.EqualFold(fields[0], fieldName) { return "", fmt.Errorf("invalid auth header format") }
     return fields[1], nil
 }
 
@@ -73,8 +159,65 @@ func GetApiKey(header http.Header) (string, error) {
     return getAuthHeaderValue(header, "ApiKey")
 }
 
-func MakeRefreshToken() (string, error) {
+// 32 random bytes, hex encoded
+func MakeRandomToken() (string, error) {
     bytes := make([]byte, 32)
     if _, err := rand.Read(bytes); err != nil { return "", err }
     return hex.EncodeToString(bytes), nil
 }
+
+func MakeRefreshToken() (string, error) {
+    return MakeRandomToken()
+}
+
+const API_KEY_PREFIX string = "chirpy_"
+
+// The prefix makes keys easy to recognize, e.g. by secret scanners, if one ends up somewhere it shouldn't
+func MakeApiKey() (string, error) {
+    token, err := MakeRandomToken()
+    if err != nil { return "", err }
+    return API_KEY_PREFIX + token, nil
+}
+
+// The start of a key, enough for a user to tell their keys apart without revealing the rest
+func GetApiKeyDisplayPrefix(key string) string {
+    return key[:min(len(key), len(API_KEY_PREFIX) + 8)]
+}
+
+// For tokens that are stored so they can be looked up later but shouldn't be usable by anyone who can
+// read the database. Random tokens are long enough that a plain sha256 is fine, unlike passwords.
+func HashToken(token string) string {
+    hash := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(hash[:])
+}
+
+// Sign an id so it can be handed out in a link, e.g. for email verification. The purpose is part of the
+// signature so a token made for one thing can't be used for another. Checking the signature first means
+// made up tokens are rejected without a database lookup.
+func MakeSignedToken(purpose string, id uuid.UUID, secret string) string {
+    encodedId := base64.RawURLEncoding.EncodeToString(id[:])
+    return encodedId + "." + base64.RawURLEncoding.EncodeToString(signTokenId(purpose, id, secret))
+}
+
+func ParseSignedToken(purpose, token, secret string) (uuid.UUID, error) {
+    var result uuid.UUID
+
+    encodedId, encodedSignature, found := strings.Cut(token, ".")
+    if !found { return result, fmt.Errorf("invalid token format") }
+    idBytes, err := base64.RawURLEncoding.DecodeString(encodedId)
+    if err != nil { return result, fmt.Errorf("invalid token format") }
+    signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+    if err != nil { return result, fmt.Errorf("invalid token format") }
+    id, err := uuid.FromBytes(idBytes)
+    if err != nil { return result, fmt.Errorf("invalid token format") }
+
+    if !hmac.Equal(signature, signTokenId(purpose, id, secret)) { return result, fmt.Errorf("invalid token signature") }
+    return id, nil
+}
+
+func signTokenId(purpose string, id uuid.UUID, secret string) []byte {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(purpose + ":"))
+    mac.Write(id[:])
+    return mac.Sum(nil)
+}
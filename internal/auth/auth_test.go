@@ -1,24 +1,34 @@
 package auth
 
 import (
+    "os"
     "testing"
     "time"
     "net/http"
+    "strings"
+    "slices"
+    "path/filepath"
+    "encoding/pem"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/ed25519"
+    "crypto/x509"
     "github.com/google/uuid"
+    "github.com/golang-jwt/jwt/v5"
 )
 
 func TestJWTCreationAndValidation(t *testing.T) {
     expectedId := uuid.New()
-    secret := "test secret"
+    keys := generateTestKeySet(t)
     expiry := 5 * time.Second
 
-    token, err := MakeJWT(expectedId, secret, expiry)
+    token, err := MakeJWT(expectedId, keys, AllScopes, expiry)
     if err != nil {
         t.Errorf("Token creation failed but shouldn't have: %v\n", err.Error())
         t.FailNow()
     }
 
-    resultId, err := ValidateJWT(token, secret)
+    resultId, scopes, err := ValidateJWT(token, keys, AccessTokenValidation)
     if err != nil {
         t.Errorf("Token validation failed but shouldn't have: %v\n", err.Error())
         t.FailNow()
@@ -28,14 +38,18 @@ func TestJWTCreationAndValidation(t *testing.T) {
         t.Errorf("decoded id doesn't match the expected id! actual: %s, expected: %s\n", resultId, expectedId)
         t.FailNow()
     }
+
+    if !slices.Equal(scopes, AllScopes) {
+        t.Errorf("decoded scopes don't match the expected scopes! actual: %v, expected: %v\n", scopes, AllScopes)
+    }
 }
 
 func TestJWTExpires(t *testing.T) {
     expectedId := uuid.New()
-    secret := "test secret"
+    keys := generateTestKeySet(t)
     expiry := 1 * time.Second
 
-    token, err := MakeJWT(expectedId, secret, expiry)
+    token, err := MakeJWT(expectedId, keys, AllScopes, expiry)
     if err != nil {
         t.Errorf("Token creation failed but shouldn't have: %v\n", err.Error())
         t.FailNow()
@@ -43,31 +57,298 @@ func TestJWTExpires(t *testing.T) {
 
     time.Sleep(2 * time.Second)
 
-    _, err = ValidateJWT(token, secret)
+    // Without leeway so the test doesn't have to wait it out
+    options := AccessTokenValidation
+    options.Leeway = 0
+    _, _, err = ValidateJWT(token, keys, options)
     if err == nil {
         t.Error("Token failed to expire")
         t.FailNow()
     }
 }
 
-func TestJWTValidationFailsWithWrongSecret(t *testing.T) {
+func TestJWTValidationFailsWithWrongKey(t *testing.T) {
     id := uuid.New()
-    goodSecret := "good token"
+    goodKeys := generateTestKeySet(t)
     expiry := 5 * time.Second
 
-    badToken, err := MakeJWT(id, "bad token", expiry)
-    if err != nil {
-        t.Errorf("Token creation failed but shouldn't have: %v\n", err.Error())
-        t.FailNow()
+    // A different key claiming to be the good key
+    badKeys := generateTestKeySet(t)
+    badKeys.signingKey.ID = goodKeys.signingKey.ID
+
+    testCases := []struct {
+        name string
+        makeToken func() (string, error)
+    }{
+        {
+            name: "unknown kid",
+            makeToken: func() (string, error) { return MakeJWT(id, generateTestKeySet(t), AllScopes, expiry) },
+        },
+        {
+            name: "known kid, wrong key",
+            makeToken: func() (string, error) { return MakeJWT(id, badKeys, AllScopes, expiry) },
+        },
+        {
+            name: "no kid",
+            makeToken: func() (string, error) {
+                claims := jwt.RegisteredClaims { Subject: id.String(), ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)) }
+                return jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims).SignedString(badKeys.signingKey.privateKey)
+            },
+        },
+        {
+            // The public key is public, so a verifier that let the token pick HS256 could be fooled by a
+            // token "signed" with it
+            name: "alg doesn't match key",
+            makeToken: func() (string, error) {
+                claims := jwt.RegisteredClaims { Subject: id.String(), ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)) }
+                token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+                token.Header["kid"] = goodKeys.signingKey.ID
+                return token.SignedString([]byte(goodKeys.signingKey.PublicKey.(ed25519.PublicKey)))
+            },
+        },
     }
 
-    _, err = ValidateJWT(badToken, goodSecret)
-    if err == nil {
-        t.Error("Validation should have failed!")
-        t.FailNow()
+    for i := range testCases {
+        testCase := testCases[i]
+        token, err := testCase.makeToken()
+        if err != nil {
+            t.Errorf("Test case %v (%v): token creation failed but shouldn't have: %v\n", i, testCase.name, err)
+            continue
+        }
+        if _, _, err := ValidateJWT(token, goodKeys, AccessTokenValidation); err == nil {
+            t.Errorf("Test case %v (%v): validation should have failed!\n", i, testCase.name)
+        }
     }
 }
 
+func TestJWTValidationOptions(t *testing.T) {
+    id := uuid.New()
+    keys := generateTestKeySet(t)
+    now := time.Now()
+    options := ValidationOptions {
+        Algorithms: []string { "EdDSA" },
+        Issuer: JWT_ISSUER,
+        Audience: ACCESS_TOKEN_AUDIENCE,
+        Leeway: 10 * time.Second,
+        RequiredClaims: []string { "exp", "iat" },
+    }
+    // A token that passes, each test case changes one thing about it
+    validClaims := func() jwt.MapClaims {
+        return jwt.MapClaims {
+            "iss": JWT_ISSUER,
+            "aud": []string { "some-other-service", ACCESS_TOKEN_AUDIENCE },
+            "sub": id.String(),
+            "iat": now.Unix(),
+            "exp": now.Add(time.Minute).Unix(),
+        }
+    }
+
+    testCases := []struct {
+        name string
+        claims func() jwt.MapClaims
+        options func() ValidationOptions
+        shouldError bool
+    }{
+        {
+            name: "valid",
+            claims: validClaims,
+            options: func() ValidationOptions { return options },
+            shouldError: false,
+        },
+        {
+            name: "algorithm not allowed",
+            claims: validClaims,
+            options: func() ValidationOptions {
+                rsaOnly := options
+                rsaOnly.Algorithms = []string { "RS256" }
+                return rsaOnly
+            },
+            shouldError: true,
+        },
+        {
+            name: "wrong issuer",
+            claims: func() jwt.MapClaims { claims := validClaims(); claims["iss"] = "not-chirpy"; return claims },
+            options: func() ValidationOptions { return options },
+            shouldError: true,
+        },
+        {
+            name: "missing issuer",
+            claims: func() jwt.MapClaims { claims := validClaims(); delete(claims, "iss"); return claims },
+            options: func() ValidationOptions { return options },
+            shouldError: true,
+        },
+        {
+            name: "wrong audience",
+            claims: func() jwt.MapClaims { claims := validClaims(); claims["aud"] = "some-other-service"; return claims },
+            options: func() ValidationOptions { return options },
+            shouldError: true,
+        },
+        {
+            name: "missing audience",
+            claims: func() jwt.MapClaims { claims := validClaims(); delete(claims, "aud"); return claims },
+            options: func() ValidationOptions { return options },
+            shouldError: true,
+        },
+        {
+            name: "expired within leeway",
+            claims: func() jwt.MapClaims { claims := validClaims(); claims["exp"] = now.Add(-5 * time.Second).Unix(); return claims },
+            options: func() ValidationOptions { return options },
+            shouldError: false,
+        },
+        {
+            name: "expired beyond leeway",
+            claims: func() jwt.MapClaims { claims := validClaims(); claims["exp"] = now.Add(-15 * time.Second).Unix(); return claims },
+            options: func() ValidationOptions { return options },
+            shouldError: true,
+        },
+        {
+            name: "not valid yet",
+            claims: func() jwt.MapClaims { claims := validClaims(); claims["nbf"] = now.Add(time.Minute).Unix(); return claims },
+            options: func() ValidationOptions { return options },
+            shouldError: true,
+        },
+        {
+            name: "issued in the future",
+            claims: func() jwt.MapClaims { claims := validClaims(); claims["iat"] = now.Add(time.Minute).Unix(); return claims },
+            options: func() ValidationOptions { return options },
+            shouldError: true,
+        },
+        {
+            name: "missing required exp",
+            claims: func() jwt.MapClaims { claims := validClaims(); delete(claims, "exp"); return claims },
+            options: func() ValidationOptions { return options },
+            shouldError: true,
+        },
+        {
+            name: "missing required iat",
+            claims: func() jwt.MapClaims { claims := validClaims(); delete(claims, "iat"); return claims },
+            options: func() ValidationOptions { return options },
+            shouldError: true,
+        },
+        {
+            name: "missing claim that isn't required",
+            claims: func() jwt.MapClaims { claims := validClaims(); delete(claims, "iat"); return claims },
+            options: func() ValidationOptions {
+                onlyExp := options
+                onlyExp.RequiredClaims = []string { "exp" }
+                return onlyExp
+            },
+            shouldError: false,
+        },
+        {
+            name: "missing subject",
+            claims: func() jwt.MapClaims { claims := validClaims(); delete(claims, "sub"); return claims },
+            options: func() ValidationOptions { return options },
+            shouldError: true,
+        },
+    }
+
+    for i := range testCases {
+        testCase := testCases[i]
+        token, err := keys.sign(testCase.claims())
+        if err != nil {
+            t.Errorf("Test case %v (%v): token creation failed but shouldn't have: %v\n", i, testCase.name, err)
+            continue
+        }
+
+        resultId, _, err := ValidateJWT(token, keys, testCase.options())
+        if !testCase.shouldError && err != nil {
+            t.Errorf("Test case %v (%v): case errored but was not expected to: %v\n", i, testCase.name, err)
+            continue
+        }
+        if testCase.shouldError && err == nil {
+            t.Errorf("Test case %v (%v): case did not error but was expected to\n", i, testCase.name)
+            continue
+        }
+        if !testCase.shouldError && resultId != id {
+            t.Errorf("Test case %v (%v): expected id %v, got %v\n", i, testCase.name, id, resultId)
+        }
+    }
+}
+
+func TestKeyRotation(t *testing.T) {
+    dir := t.TempDir()
+    rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil { t.Fatalf("Failed to generate rsa key: %v\n", err) }
+    _, edKey, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil { t.Fatalf("Failed to generate ed25519 key: %v\n", err) }
+    _, retiredKey, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil { t.Fatalf("Failed to generate ed25519 key: %v\n", err) }
+
+    writeTestKey(t, dir, "2023-01-01.pem", "PRIVATE KEY", retiredKey)
+    writeTestKey(t, dir, "2024-01-01.pem", "PRIVATE KEY", rsaKey)
+    keysBefore, err := LoadKeySet(dir)
+    if err != nil { t.Fatalf("Failed to load keys: %v\n", err) }
+    if keysBefore.SigningKeyID() != "2024-01-01" {
+        t.Fatalf("Expected 2024-01-01 to be the signing key, got %v\n", keysBefore.SigningKeyID())
+    }
+
+    id := uuid.New()
+    retiredToken, err := MakeJWT(id, keysBefore, AllScopes, 5 * time.Second)
+    if err != nil { t.Fatalf("Token creation failed but shouldn't have: %v\n", err) }
+    keysBefore.signingKey = keysBefore.keys["2023-01-01"]
+    removedToken, err := MakeJWT(id, keysBefore, AllScopes, 5 * time.Second)
+    if err != nil { t.Fatalf("Token creation failed but shouldn't have: %v\n", err) }
+
+    // Rotate: add a newer key, only keep the public half of the old rsa key and drop the oldest key entirely
+    writeTestKey(t, dir, "2024-06-01.pem", "PRIVATE KEY", edKey)
+    writeTestKey(t, dir, "2024-01-01.pem", "PUBLIC KEY", rsaKey.Public())
+    if err := os.Remove(filepath.Join(dir, "2023-01-01.pem")); err != nil { t.Fatalf("Failed to remove key: %v\n", err) }
+    keysAfter, err := LoadKeySet(dir)
+    if err != nil { t.Fatalf("Failed to load keys: %v\n", err) }
+    if keysAfter.SigningKeyID() != "2024-06-01" {
+        t.Fatalf("Expected 2024-06-01 to be the signing key, got %v\n", keysAfter.SigningKeyID())
+    }
+
+    if resultId, _, err := ValidateJWT(retiredToken, keysAfter, AccessTokenValidation); err != nil || resultId != id {
+        t.Errorf("Token signed before the rotation should still be valid: %v\n", err)
+    }
+    if _, _, err := ValidateJWT(removedToken, keysAfter, AccessTokenValidation); err == nil {
+        t.Error("Token signed with a removed key should be invalid")
+    }
+    newToken, err := MakeJWT(id, keysAfter, AllScopes, 5 * time.Second)
+    if err != nil { t.Fatalf("Token creation failed but shouldn't have: %v\n", err) }
+    if resultId, _, err := ValidateJWT(newToken, keysAfter, AccessTokenValidation); err != nil || resultId != id {
+        t.Errorf("Token signed after the rotation should be valid: %v\n", err)
+    }
+
+    jwks := keysAfter.JWKS()
+    if len(jwks.Keys) != 2 {
+        t.Fatalf("Expected 2 keys in the jwks, got %v\n", len(jwks.Keys))
+    }
+    if jwks.Keys[0].Kid != "2024-01-01" || jwks.Keys[0].Kty != "RSA" || jwks.Keys[0].Alg != "RS256" || jwks.Keys[0].E != "AQAB" {
+        t.Errorf("Unexpected rsa jwk: %+v\n", jwks.Keys[0])
+    }
+    if jwks.Keys[1].Kid != "2024-06-01" || jwks.Keys[1].Kty != "OKP" || jwks.Keys[1].Crv != "Ed25519" || jwks.Keys[1].Alg != "EdDSA" {
+        t.Errorf("Unexpected ed25519 jwk: %+v\n", jwks.Keys[1])
+    }
+
+    // Only public keys left, nothing to sign with
+    if err := os.Remove(filepath.Join(dir, "2024-06-01.pem")); err != nil { t.Fatalf("Failed to remove key: %v\n", err) }
+    if _, err := LoadKeySet(dir); err == nil {
+        t.Error("Loading a key set without a private key should have failed")
+    }
+}
+
+func generateTestKeySet(t *testing.T) *KeySet {
+    keys, err := GenerateKeySet()
+    if err != nil { t.Fatalf("Failed to generate key set: %v\n", err) }
+    return keys
+}
+
+func writeTestKey(t *testing.T, dir, name, blockType string, key any) {
+    var der []byte
+    var err error
+    if blockType == "PUBLIC KEY" {
+        der, err = x509.MarshalPKIXPublicKey(key)
+    } else {
+        der, err = x509.MarshalPKCS8PrivateKey(key)
+    }
+    if err != nil { t.Fatalf("Failed to marshal key: %v\n", err) }
+    data := pem.EncodeToMemory(&pem.Block { Type: blockType, Bytes: der })
+    if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil { t.Fatalf("Failed to write key: %v\n", err) }
+}
+
 func TestGetBearerToken(t *testing.T) {
     testCases := []struct {
         in http.Header
@@ -79,11 +360,26 @@ func TestGetBearerToken(t *testing.T) {
             expectedOut: "testauth",
             shouldError: false,
         },
+        {
+            in: http.Header { "Authorization": { "bearer testauth" } },
+            expectedOut: "testauth",
+            shouldError: false,
+        },
+        {
+            in: http.Header { "Authorization": { "BEARER testauth" } },
+            expectedOut: "testauth",
+            shouldError: false,
+        },
         {
             in: http.Header { "Authorization": { "testauth" } },
             expectedOut: "",
             shouldError: true,
         },
+        {
+            in: http.Header { "Authorization": { "ApiKey testauth" } },
+            expectedOut: "",
+            shouldError: true,
+        },
         {
             in: http.Header { "Authorization": { "Bearer" } },
             expectedOut: "",
@@ -126,3 +422,294 @@ func TestGetBearerToken(t *testing.T) {
         }
     }
 }
+
+func TestSignedTokens(t *testing.T) {
+    id := uuid.New()
+    secret := "test secret"
+    token := MakeSignedToken("verify", id, secret)
+    otherToken := MakeSignedToken("verify", uuid.New(), secret)
+    encodedId, _, _ := strings.Cut(token, ".")
+    _, otherSignature, _ := strings.Cut(otherToken, ".")
+
+    testCases := []struct {
+        purpose string
+        token string
+        secret string
+        shouldError bool
+    }{
+        { purpose: "verify", token: token, secret: secret, shouldError: false },
+        { purpose: "verify", token: token, secret: "wrong secret", shouldError: true },
+        { purpose: "reset", token: token, secret: secret, shouldError: true },
+        { purpose: "verify", token: encodedId + "." + otherSignature, secret: secret, shouldError: true },
+        { purpose: "verify", token: encodedId, secret: secret, shouldError: true },
+        { purpose: "verify", token: "not.a-token", secret: secret, shouldError: true },
+        { purpose: "verify", token: "", secret: secret, shouldError: true },
+    }
+
+    for i := range testCases {
+        testCase := testCases[i]
+        out, err := ParseSignedToken(testCase.purpose, testCase.token, testCase.secret)
+
+        if !testCase.shouldError && err != nil {
+            t.Errorf("Test case %v: case errored but was not expected to: %v\n", i, err)
+            continue
+        }
+
+        if testCase.shouldError && err == nil {
+            t.Errorf("Test case %v: case did not error but was expected to\n", i)
+            continue
+        }
+
+        if !testCase.shouldError && out != id {
+            t.Errorf("Test case %v: unexpected id (actual %v != expected %v)\n", i, out, id)
+        }
+    }
+}
+
+func TestHashToken(t *testing.T) {
+    token, err := MakeRandomToken()
+    if err != nil {
+        t.Errorf("Token creation failed but shouldn't have: %v\n", err.Error())
+        t.FailNow()
+    }
+    otherToken, err := MakeRandomToken()
+    if err != nil {
+        t.Errorf("Token creation failed but shouldn't have: %v\n", err.Error())
+        t.FailNow()
+    }
+
+    if HashToken(token) != HashToken(token) {
+        t.Error("Hashing the same token twice gave different hashes")
+    }
+    if HashToken(token) == HashToken(otherToken) {
+        t.Error("Different tokens have the same hash")
+    }
+    if HashToken(token) == token {
+        t.Error("Hash is the same as the token")
+    }
+    // sha256 of the empty string
+    expected := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+    if actual := HashToken(""); actual != expected {
+        t.Errorf("unexpected hash (actual %v != expected %v)\n", actual, expected)
+    }
+}
+
+func TestTotpCode(t *testing.T) {
+    // The SHA1 test vectors from RFC 6238, which are 8 digits long. The 6 digit codes are their last 6 digits.
+    secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+    testCases := []struct {
+        unixTime int64
+        expectedOut string
+    }{
+        { unixTime: 59, expectedOut: "287082" },
+        { unixTime: 1111111109, expectedOut: "081804" },
+        { unixTime: 1111111111, expectedOut: "050471" },
+        { unixTime: 1234567890, expectedOut: "005924" },
+        { unixTime: 2000000000, expectedOut: "279037" },
+        { unixTime: 20000000000, expectedOut: "353130" },
+    }
+
+    for i := range testCases {
+        testCase := testCases[i]
+        out, err := MakeTotpCode(secret, GetTotpStep(time.Unix(testCase.unixTime, 0)))
+        if err != nil {
+            t.Errorf("Test case %v: case errored but was not expected to: %v\n", i, err)
+            continue
+        }
+        if out != testCase.expectedOut {
+            t.Errorf("Test case %v: expected %v, got %v\n", i, testCase.expectedOut, out)
+        }
+    }
+}
+
+func TestValidateTotpCode(t *testing.T) {
+    secret, err := GenerateTotpSecret()
+    if err != nil { t.Fatalf("Failed to generate totp secret: %v\n", err) }
+    now := time.Now()
+    codeAt := func(offset time.Duration) string {
+        code, err := MakeTotpCode(secret, GetTotpStep(now.Add(offset)))
+        if err != nil { t.Fatalf("Failed to make totp code: %v\n", err) }
+        return code
+    }
+
+    testCases := []struct {
+        code string
+        expectedStep int64
+        shouldPass bool
+    }{
+        { code: codeAt(0), expectedStep: GetTotpStep(now), shouldPass: true },
+        { code: " " + codeAt(0) + " ", expectedStep: GetTotpStep(now), shouldPass: true },
+        { code: codeAt(-TOTP_PERIOD), expectedStep: GetTotpStep(now) - 1, shouldPass: true },
+        { code: codeAt(TOTP_PERIOD), expectedStep: GetTotpStep(now) + 1, shouldPass: true },
+        { code: codeAt(-3 * TOTP_PERIOD), shouldPass: false },
+        { code: codeAt(3 * TOTP_PERIOD), shouldPass: false },
+        { code: "", shouldPass: false },
+        { code: "12345", shouldPass: false },
+        { code: codeAt(0) + "0", shouldPass: false },
+    }
+
+    for i := range testCases {
+        testCase := testCases[i]
+        step, ok := ValidateTotpCode(secret, testCase.code, now)
+        if ok != testCase.shouldPass {
+            t.Errorf("Test case %v: expected validation to return %v, got %v\n", i, testCase.shouldPass, ok)
+            continue
+        }
+        if ok && step != testCase.expectedStep {
+            t.Errorf("Test case %v: expected step %v, got %v\n", i, testCase.expectedStep, step)
+        }
+    }
+
+    if _, ok := ValidateTotpCode("not base32!", "123456", now); ok {
+        t.Error("Validation with an invalid secret should have failed")
+    }
+}
+
+func TestRecoveryCodes(t *testing.T) {
+    codes, err := MakeRecoveryCodes()
+    if err != nil { t.Fatalf("Failed to make recovery codes: %v\n", err) }
+    if len(codes) != RECOVERY_CODE_COUNT {
+        t.Fatalf("Expected %v recovery codes, got %v\n", RECOVERY_CODE_COUNT, len(codes))
+    }
+
+    seen := map[string]bool {}
+    for _, code := range codes {
+        if len(code) != 19 || strings.Count(code, "-") != 3 {
+            t.Errorf("Recovery code %v isn't formatted like xxxx-xxxx-xxxx-xxxx\n", code)
+        }
+        normalized := NormalizeRecoveryCode(code)
+        if seen[normalized] { t.Errorf("Recovery code %v was generated twice\n", code) }
+        seen[normalized] = true
+        if NormalizeRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", " "))) != normalized {
+            t.Errorf("Recovery code %v doesn't normalize the same when typed differently\n", code)
+        }
+    }
+}
+
+func TestMfaChallengeJWT(t *testing.T) {
+    id := uuid.New()
+    keys := generateTestKeySet(t)
+
+    mfaToken, err := MakeMfaChallengeJWT(id, keys, time.Minute)
+    if err != nil { t.Fatalf("Token creation failed but shouldn't have: %v\n", err) }
+    accessToken, err := MakeJWT(id, keys, AllScopes, time.Minute)
+    if err != nil { t.Fatalf("Token creation failed but shouldn't have: %v\n", err) }
+
+    challenge, err := ValidateMfaChallengeJWT(mfaToken, keys)
+    if err != nil || challenge.UserID != id {
+        t.Errorf("Mfa token validation failed but shouldn't have: %v\n", err)
+    }
+    if challenge.ID == uuid.Nil { t.Error("Mfa token doesn't have an id to mark it used with") }
+    if time.Until(challenge.ExpiresAt) <= 0 || time.Until(challenge.ExpiresAt) > time.Minute {
+        t.Errorf("Expected the mfa token to expire within a minute, got %v\n", challenge.ExpiresAt)
+    }
+    otherToken, err := MakeMfaChallengeJWT(id, keys, time.Minute)
+    if err != nil { t.Fatalf("Token creation failed but shouldn't have: %v\n", err) }
+    if otherChallenge, err := ValidateMfaChallengeJWT(otherToken, keys); err != nil || otherChallenge.ID == challenge.ID {
+        t.Errorf("Two mfa tokens got the same id: %v\n", err)
+    }
+    if _, _, err := ValidateJWT(mfaToken, keys, AccessTokenValidation); err == nil {
+        t.Error("Mfa token shouldn't be accepted as an access token")
+    }
+    if _, err := ValidateMfaChallengeJWT(accessToken, keys); err == nil {
+        t.Error("Access token shouldn't be accepted as an mfa token")
+    }
+}
+
+// Cheap enough to keep the tests fast
+var testArgon2idHasher = Argon2idHasher { Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32 }
+
+func TestArgon2idHasher(t *testing.T) {
+    hash, err := testArgon2idHasher.Hash("hunter2")
+    if err != nil { t.Fatalf("Hashing failed but shouldn't have: %v\n", err) }
+    if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+        t.Errorf("Hash %v isn't a PHC string with the hasher's parameters\n", hash)
+    }
+    if !testArgon2idHasher.Recognizes(hash) { t.Error("Hasher doesn't recognize its own hash") }
+    if err := testArgon2idHasher.Compare("hunter2", hash); err != nil { t.Errorf("Correct password was rejected: %v\n", err) }
+    if err := testArgon2idHasher.Compare("hunter3", hash); err != ErrPasswordMismatch {
+        t.Errorf("Expected ErrPasswordMismatch for the wrong password, got %v\n", err)
+    }
+    // The parameters come from the hash, not the hasher
+    if err := DefaultArgon2idHasher.Compare("hunter2", hash); err != nil { t.Errorf("Hash with other parameters was rejected: %v\n", err) }
+
+    otherHash, err := testArgon2idHasher.Hash("hunter2")
+    if err != nil { t.Fatalf("Hashing failed but shouldn't have: %v\n", err) }
+    if otherHash == hash { t.Error("Hashing the same password twice gave the same hash") }
+
+    if testArgon2idHasher.NeedsRehash(hash) { t.Error("Hash with the hasher's own parameters needs a rehash") }
+    stronger := testArgon2idHasher
+    stronger.Iterations = 2
+    if !stronger.NeedsRehash(hash) { t.Error("Hash with fewer iterations than the hasher uses doesn't need a rehash") }
+
+    for _, malformed := range []string {
+        "$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+        "$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA",
+        "$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaA",
+        "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$!!!",
+    } {
+        if err := testArgon2idHasher.Compare("hunter2", malformed); err == nil || err == ErrPasswordMismatch {
+            t.Errorf("Expected a format error for malformed hash %v, got %v\n", malformed, err)
+        }
+    }
+}
+
+func TestPasswordsCheck(t *testing.T) {
+    passwords, err := NewPasswords(testArgon2idHasher)
+    if err != nil { t.Fatalf("Failed to set up passwords: %v\n", err) }
+
+    legacyHasher := BcryptHasher { Cost: 4 }
+    legacyHash, err := legacyHasher.Hash("hunter2")
+    if err != nil { t.Fatalf("Hashing failed but shouldn't have: %v\n", err) }
+    currentHash, err := passwords.Hash("hunter2")
+    if err != nil { t.Fatalf("Hashing failed but shouldn't have: %v\n", err) }
+    oldParamsHasher := testArgon2idHasher
+    oldParamsHasher.Memory = 32
+    oldParamsHash, err := oldParamsHasher.Hash("hunter2")
+    if err != nil { t.Fatalf("Hashing failed but shouldn't have: %v\n", err) }
+    // bcrypt would only have looked at the first 72 bytes of these
+    longPassword := strings.Repeat("a", 72)
+    longHash, err := passwords.Hash(longPassword + "b")
+    if err != nil { t.Fatalf("Hashing failed but shouldn't have: %v\n", err) }
+
+    type TestCase struct {
+        password string
+        hash string
+        expectError bool
+        expectRehash bool
+    }
+    testCases := []TestCase {
+        { password: "hunter2", hash: currentHash },
+        { password: "hunter3", hash: currentHash, expectError: true },
+        { password: "hunter2", hash: legacyHash, expectRehash: true },
+        { password: "hunter3", hash: legacyHash, expectError: true },
+        { password: "hunter2", hash: oldParamsHash, expectRehash: true },
+        { password: longPassword + "b", hash: longHash },
+        { password: longPassword + "c", hash: longHash, expectError: true },
+        { password: "hunter2", hash: "hunter2", expectError: true },
+        { password: "hunter2", hash: "", expectError: true },
+    }
+
+    for i, testCase := range testCases {
+        needsRehash, err := passwords.Check(testCase.password, testCase.hash)
+        if testCase.expectError {
+            if err == nil { t.Errorf("Test case %v: check succeeded but should have failed\n", i) }
+            continue
+        }
+        if err != nil { t.Errorf("Test case %v: check failed but shouldn't have: %v\n", i, err) }
+        if needsRehash != testCase.expectRehash {
+            t.Errorf("Test case %v: expected needsRehash to be %v, got %v\n", i, testCase.expectRehash, needsRehash)
+        }
+    }
+
+    bcryptPasswords, err := NewPasswords(legacyHasher)
+    if err != nil { t.Fatalf("Failed to set up passwords: %v\n", err) }
+    if needsRehash, err := bcryptPasswords.Check("hunter2", legacyHash); err != nil || needsRehash {
+        t.Errorf("bcrypt hash with the configured cost failed the check or needs a rehash: %v\n", err)
+    }
+    if !(BcryptHasher {}).NeedsRehash(legacyHash) { t.Error("bcrypt hash with cost 4 doesn't need a rehash to the default cost") }
+
+    if _, err := legacyHasher.Hash(longPassword + "b"); err != ErrPasswordTooLong {
+        t.Errorf("Expected ErrPasswordTooLong when bcrypt hashes a password longer than 72 bytes, got %v\n", err)
+    }
+}
@@ -0,0 +1,180 @@
+package auth
+
+import (
+    "os"
+    "fmt"
+    "sort"
+    "strings"
+    "math/big"
+    "path/filepath"
+    "encoding/pem"
+    "encoding/hex"
+    "encoding/base64"
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/ed25519"
+    "crypto/x509"
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const MIN_RSA_KEY_BITS int = 2048
+
+// A key access tokens are signed or verified with. Retired keys only have the public half.
+type Key struct {
+    ID          string
+    Method      jwt.SigningMethod
+    PublicKey   crypto.PublicKey
+    privateKey  crypto.Signer
+}
+
+// The keys access tokens are verified with, one of which is used to sign new tokens. Keeping old keys around
+// after a new signing key is added means tokens signed before the rotation stay valid until they expire.
+type KeySet struct {
+    signingKey  *Key
+    keys        map[string]*Key
+}
+
+// Load every .pem file in dir. The file name without the extension is the key id. Private keys (PKCS#8, or
+// PKCS#1 for RSA) can sign, public keys (PKIX) can only verify. The private key whose file name sorts last
+// signs new tokens, so naming files by date (e.g. 2024-06-01.pem) makes the newest key the signing key.
+func LoadKeySet(dir string) (*KeySet, error) {
+    paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+    if err != nil { return nil, err }
+    sort.Strings(paths)
+
+    keys := KeySet { keys: make(map[string]*Key) }
+    for _, path := range paths {
+        data, err := os.ReadFile(path)
+        if err != nil { return nil, err }
+        key, err := parseKey(data)
+        if err != nil { return nil, fmt.Errorf("%v: %w", path, err) }
+        key.ID = strings.TrimSuffix(filepath.Base(path), ".pem")
+        keys.keys[key.ID] = key
+        if key.privateKey != nil { keys.signingKey = key }
+    }
+
+    if keys.signingKey == nil { return nil, fmt.Errorf("no private key found in %v", dir) }
+    return &keys, nil
+}
+
+// A set with a single newly generated Ed25519 key. Tokens signed with it stop being valid when the process
+// exits, so this is only meant for development.
+func GenerateKeySet() (*KeySet, error) {
+    publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil { return nil, err }
+    idBytes := make([]byte, 8)
+    if _, err := rand.Read(idBytes); err != nil { return nil, err }
+
+    key := Key {
+        ID: "ephemeral-" + hex.EncodeToString(idBytes),
+        Method: jwt.SigningMethodEdDSA,
+        PublicKey: publicKey,
+        privateKey: privateKey,
+    }
+    return &KeySet { signingKey: &key, keys: map[string]*Key { key.ID: &key } }, nil
+}
+
+func parseKey(data []byte) (*Key, error) {
+    block, _ := pem.Decode(data)
+    if block == nil { return nil, fmt.Errorf("no pem block found") }
+
+    var parsed any
+    var err error
+    switch block.Type {
+    case "PRIVATE KEY": parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+    case "RSA PRIVATE KEY": parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+    case "PUBLIC KEY": parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+    default: return nil, fmt.Errorf("unsupported pem block type %v", block.Type)
+    }
+    if err != nil { return nil, err }
+
+    var key Key
+    if signer, ok := parsed.(crypto.Signer); ok {
+        key.privateKey = signer
+        parsed = signer.Public()
+    }
+    key.PublicKey = parsed
+    switch publicKey := parsed.(type) {
+    case *rsa.PublicKey:
+        if publicKey.N.BitLen() < MIN_RSA_KEY_BITS {
+            return nil, fmt.Errorf("rsa keys must be at least %v bits", MIN_RSA_KEY_BITS)
+        }
+        key.Method = jwt.SigningMethodRS256
+    case ed25519.PublicKey:
+        key.Method = jwt.SigningMethodEdDSA
+    default:
+        return nil, fmt.Errorf("unsupported key type %T, only rsa and ed25519 keys are supported", parsed)
+    }
+
+    return &key, nil
+}
+
+// The id of the key new tokens are signed with
+func (keys *KeySet) SigningKeyID() string {
+    return keys.signingKey.ID
+}
+
+// Sign a token with the signing key, setting its kid header so verifiers know which key to check it with
+func (keys *KeySet) sign(claims jwt.Claims) (string, error) {
+    token := jwt.NewWithClaims(keys.signingKey.Method, claims)
+    token.Header["kid"] = keys.signingKey.ID
+    return token.SignedString(keys.signingKey.privateKey)
+}
+
+// A jwt.Keyfunc that picks the verification key by the token's kid header. The token's alg header has to
+// match the algorithm of that key, otherwise a token could pick an algorithm the key wasn't meant for.
+func (keys *KeySet) keyFunc(token *jwt.Token) (any, error) {
+    id, ok := token.Header["kid"].(string)
+    if !ok { return nil, fmt.Errorf("token has no kid header") }
+    key, ok := keys.keys[id]
+    if !ok { return nil, fmt.Errorf("unknown key id %v", id) }
+    if token.Method.Alg() != key.Method.Alg() {
+        return nil, fmt.Errorf("key %v is for %v but token uses %v", id, key.Method.Alg(), token.Method.Alg())
+    }
+    return key.PublicKey, nil
+}
+
+// A public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+    Kty string `json:"kty"`
+    Use string `json:"use"`
+    Alg string `json:"alg"`
+    Kid string `json:"kid"`
+    // RSA
+    N   string `json:"n,omitempty"`
+    E   string `json:"e,omitempty"`
+    // Ed25519
+    Crv string `json:"crv,omitempty"`
+    X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+    Keys []JWK `json:"keys"`
+}
+
+// The public halves of every key in the set, for other services to verify tokens with
+func (keys *KeySet) JWKS() JWKS {
+    ids := make([]string, 0, len(keys.keys))
+    for id := range keys.keys { ids = append(ids, id) }
+    sort.Strings(ids)
+
+    result := JWKS { Keys: make([]JWK, 0, len(ids)) }
+    for _, id := range ids {
+        key := keys.keys[id]
+        jwk := JWK { Use: "sig", Alg: key.Method.Alg(), Kid: key.ID }
+        switch publicKey := key.PublicKey.(type) {
+        case *rsa.PublicKey:
+            jwk.Kty = "RSA"
+            jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+            jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+        case ed25519.PublicKey:
+            jwk.Kty = "OKP"
+            jwk.Crv = "Ed25519"
+            jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+        }
+        result.Keys = append(result.Keys, jwk)
+    }
+
+    return result
+}
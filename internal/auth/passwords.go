@@ -0,0 +1,180 @@
+package auth
+
+import (
+    "fmt"
+    "errors"
+    "strings"
+    "encoding/base64"
+    "crypto/rand"
+    "crypto/subtle"
+    "golang.org/x/crypto/argon2"
+    "golang.org/x/crypto/bcrypt"
+)
+
+var ErrPasswordMismatch = errors.New("password doesn't match")
+var ErrPasswordTooLong = errors.New("password can't be longer than 72 bytes")
+
+// A way of hashing passwords. Hashes say which hasher made them and with what settings, so a hasher can check
+// any hash it recognizes regardless of the settings it was configured with.
+type PasswordHasher interface {
+    Hash(password string) (string, error)
+    Recognizes(hash string) bool
+    // Returns ErrPasswordMismatch if password isn't the one that was hashed
+    Compare(password, hash string) error
+    // Whether hash was made with different settings than the hasher uses now
+    NeedsRehash(hash string) bool
+}
+
+// Argon2id (RFC 9106), stored in the PHC string format: $argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>$<salt>$<key>
+type Argon2idHasher struct {
+    // In KiB
+    Memory      uint32
+    Iterations  uint32
+    Parallelism uint8
+    SaltLength  uint32
+    KeyLength   uint32
+}
+
+// The second recommended option of RFC 9106, for when 2 GiB of memory per hash is too much
+var DefaultArgon2idHasher = Argon2idHasher {
+    Memory: 64 * 1024,
+    Iterations: 3,
+    Parallelism: 4,
+    SaltLength: 16,
+    KeyLength: 32,
+}
+
+type argon2idHash struct {
+    memory      uint32
+    iterations  uint32
+    parallelism uint8
+    salt        []byte
+    key         []byte
+}
+
+func (hasher Argon2idHasher) Hash(password string) (string, error) {
+    salt := make([]byte, hasher.SaltLength)
+    if _, err := rand.Read(salt); err != nil { return "", err }
+    key := argon2.IDKey([]byte(password), salt, hasher.Iterations, hasher.Memory, hasher.Parallelism, hasher.KeyLength)
+    return fmt.Sprintf(
+        "$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+        argon2.Version,
+        hasher.Memory,
+        hasher.Iterations,
+        hasher.Parallelism,
+        base64.RawStdEncoding.EncodeToString(salt),
+        base64.RawStdEncoding.EncodeToString(key),
+    ), nil
+}
+
+func (hasher Argon2idHasher) Recognizes(hash string) bool {
+    return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func parseArgon2idHash(hash string) (argon2idHash, error) {
+    var result argon2idHash
+
+    // The leading $ makes the first part empty
+    parts := strings.Split(hash, "$")
+    if len(parts) != 6 || parts[1] != "argon2id" { return result, fmt.Errorf("invalid argon2id hash format") }
+    var version int
+    if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil { return result, fmt.Errorf("invalid argon2id hash format") }
+    if version != argon2.Version { return result, fmt.Errorf("unsupported argon2 version %v", version) }
+    _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &result.memory, &result.iterations, &result.parallelism)
+    if err != nil { return result, fmt.Errorf("invalid argon2id hash format") }
+    result.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+    if err != nil { return result, fmt.Errorf("invalid argon2id hash format") }
+    result.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+    if err != nil || len(result.key) == 0 { return result, fmt.Errorf("invalid argon2id hash format") }
+
+    return result, nil
+}
+
+func (hasher Argon2idHasher) Compare(password, hash string) error {
+    parsed, err := parseArgon2idHash(hash)
+    if err != nil { return err }
+    key := argon2.IDKey([]byte(password), parsed.salt, parsed.iterations, parsed.memory, parsed.parallelism, uint32(len(parsed.key)))
+    if subtle.ConstantTimeCompare(key, parsed.key) != 1 { return ErrPasswordMismatch }
+    return nil
+}
+
+func (hasher Argon2idHasher) NeedsRehash(hash string) bool {
+    parsed, err := parseArgon2idHash(hash)
+    if err != nil { return true }
+    return parsed.memory != hasher.Memory ||
+        parsed.iterations != hasher.Iterations ||
+        parsed.parallelism != hasher.Parallelism ||
+        uint32(len(parsed.salt)) != hasher.SaltLength ||
+        uint32(len(parsed.key)) != hasher.KeyLength
+}
+
+// What passwords used to be hashed with. Only the first 72 bytes of a password count, so longer passwords are
+// refused instead of silently being cut short.
+type BcryptHasher struct {
+    Cost int
+}
+
+func (hasher BcryptHasher) Hash(password string) (string, error) {
+    if len(password) > 72 { return "", ErrPasswordTooLong }
+    hashed, err := bcrypt.GenerateFromPassword([]byte(password), hasher.Cost)
+    if err != nil { return "", err }
+    return string(hashed), nil
+}
+
+func (hasher BcryptHasher) Recognizes(hash string) bool {
+    return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (hasher BcryptHasher) Compare(password, hash string) error {
+    err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+    if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) { return ErrPasswordMismatch }
+    return err
+}
+
+func (hasher BcryptHasher) NeedsRehash(hash string) bool {
+    // A cost of 0 is what bcrypt itself treats as the default
+    expectedCost := hasher.Cost
+    if expectedCost == 0 { expectedCost = bcrypt.DefaultCost }
+    cost, err := bcrypt.Cost([]byte(hash))
+    return err != nil || cost != expectedCost
+}
+
+// Hashes new passwords with one hasher, but checks passwords against hashes from any supported hasher so
+// switching hashers doesn't lock anyone out
+type Passwords struct {
+    hasher      PasswordHasher
+    verifiers   []PasswordHasher
+    dummyHash   string
+}
+
+func NewPasswords(hasher PasswordHasher) (*Passwords, error) {
+    passwords := Passwords {
+        hasher: hasher,
+        verifiers: []PasswordHasher { hasher, DefaultArgon2idHasher, BcryptHasher {} },
+    }
+    dummyHash, err := hasher.Hash("chirpy dummy password")
+    if err != nil { return nil, err }
+    passwords.dummyHash = dummyHash
+    return &passwords, nil
+}
+
+func (passwords *Passwords) Hash(password string) (string, error) {
+    return passwords.hasher.Hash(password)
+}
+
+// Check a password against its hash. If it matches but the hash wasn't made by the current hasher with its
+// current settings, needsRehash is set so the caller can store a new hash while it has the password.
+func (passwords *Passwords) Check(password, hash string) (needsRehash bool, err error) {
+    for _, verifier := range passwords.verifiers {
+        if !verifier.Recognizes(hash) { continue }
+        if err := verifier.Compare(password, hash); err != nil { return false, err }
+        return verifier != passwords.hasher || passwords.hasher.NeedsRehash(hash), nil
+    }
+    return false, fmt.Errorf("unrecognized password hash format")
+}
+
+// Spend as long as Check would when there's no hash to check against, e.g. when logging in with an email
+// that has no account, so response times don't give away which emails have accounts
+func (passwords *Passwords) CheckDummy(password string) {
+    passwords.Check(password, passwords.dummyHash)
+}
@@ -0,0 +1,26 @@
+package auth
+
+import (
+    "slices"
+)
+
+// What a credential is allowed to do. Tokens from logging in get every scope, API keys only get the ones
+// they were created with.
+const (
+    // Read timelines, notifications and anything else only visible to the user
+    SCOPE_CHIRPS_READ string = "chirps:read"
+    // Post, edit and delete chirps, like, rechirp and follow
+    SCOPE_CHIRPS_WRITE string = "chirps:write"
+    // Change the profile, email and password, manage sessions and API keys, export or delete the account
+    SCOPE_ACCOUNT_ADMIN string = "account:admin"
+)
+
+var AllScopes = []string { SCOPE_CHIRPS_READ, SCOPE_CHIRPS_WRITE, SCOPE_ACCOUNT_ADMIN }
+
+func IsValidScope(scope string) bool {
+    return slices.Contains(AllScopes, scope)
+}
+
+func HasScope(scopes []string, scope string) bool {
+    return slices.Contains(scopes, scope)
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+    "fmt"
+    "time"
+    "strings"
+    "net/url"
+    "encoding/binary"
+    "encoding/base32"
+    "crypto/rand"
+    "crypto/hmac"
+    "crypto/sha1"
+    "crypto/subtle"
+)
+
+// Time-based one-time passwords (RFC 6238) with the parameters every authenticator app supports
+const TOTP_PERIOD time.Duration = 30 * time.Second
+const TOTP_DIGITS int = 6
+// How many periods a code may be off by, for clocks that have drifted and codes typed in right as they change
+const TOTP_SKEW int64 = 1
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// 160 random bits, base32 encoded like authenticator apps expect
+func GenerateTotpSecret() (string, error) {
+    bytes := make([]byte, 20)
+    if _, err := rand.Read(bytes); err != nil { return "", err }
+    return totpEncoding.EncodeToString(bytes), nil
+}
+
+// The URI that authenticator apps read from a QR code
+func MakeTotpUri(issuer, accountName, secret string) string {
+    query := url.Values {}
+    query.Set("secret", secret)
+    query.Set("issuer", issuer)
+    query.Set("algorithm", "SHA1")
+    query.Set("digits", fmt.Sprint(TOTP_DIGITS))
+    query.Set("period", fmt.Sprint(int(TOTP_PERIOD.Seconds())))
+    label := url.PathEscape(issuer) + ":" + url.PathEscape(accountName)
+    return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// The period a point in time falls into. Each period has its own code.
+func GetTotpStep(t time.Time) int64 {
+    return t.Unix() / int64(TOTP_PERIOD.Seconds())
+}
+
+func MakeTotpCode(secret string, step int64) (string, error) {
+    key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+    if err != nil { return "", fmt.Errorf("invalid totp secret") }
+
+    // HOTP (RFC 4226) with the step as the counter
+    var counter [8]byte
+    binary.BigEndian.PutUint64(counter[:], uint64(step))
+    mac := hmac.New(sha1.New, key)
+    mac.Write(counter[:])
+    sum := mac.Sum(nil)
+    offset := sum[len(sum) - 1] & 0x0f
+    truncated := binary.BigEndian.Uint32(sum[offset:offset + 4]) & 0x7fffffff
+
+    modulus := uint32(1)
+    for range TOTP_DIGITS { modulus *= 10 }
+    return fmt.Sprintf("%0*d", TOTP_DIGITS, truncated % modulus), nil
+}
+
+// Check a code against the periods around t. The step the code belongs to is returned so the caller can
+// make sure the same code isn't accepted twice.
+func ValidateTotpCode(secret, code string, t time.Time) (int64, bool) {
+    code = strings.TrimSpace(code)
+    if len(code) != TOTP_DIGITS { return 0, false }
+
+    current := GetTotpStep(t)
+    for step := current - TOTP_SKEW; step <= current + TOTP_SKEW; step++ {
+        expected, err := MakeTotpCode(secret, step)
+        if err != nil { return 0, false }
+        if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 { return step, true }
+    }
+    return 0, false
+}
+
+const RECOVERY_CODE_COUNT int = 10
+
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Single use codes for logging in without the authenticator, formatted like xxxx-xxxx-xxxx-xxxx. They have
+// 80 random bits, enough that storing them with HashToken is fine.
+func MakeRecoveryCodes() ([]string, error) {
+    codes := make([]string, 0, RECOVERY_CODE_COUNT)
+    for range RECOVERY_CODE_COUNT {
+        bytes := make([]byte, 10)
+        if _, err := rand.Read(bytes); err != nil { return nil, err }
+        encoded := recoveryCodeEncoding.EncodeToString(bytes)
+        codes = append(codes, encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16])
+    }
+    return codes, nil
+}
+
+// Recovery codes are hashed in this form, so they can be typed in any case with or without the dashes
+func NormalizeRecoveryCode(code string) string {
+    code = strings.ToLower(code)
+    return strings.Map(func(r rune) rune {
+        if r == '-' || r == ' ' { return -1 }
+        return r
+    }, code)
+}
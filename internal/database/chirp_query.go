@@ -0,0 +1,148 @@
+package database
+
+// Hand-written (not generated by sqlc): chirp listings take an arbitrary combination of filters which
+// sqlc can't express without a query per combination.
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "strings"
+
+    "github.com/google/uuid"
+)
+
+const chirpColumns = "id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of"
+
+// Every field is optional; the zero value lists all chirps oldest first.
+type ListChirpsParams struct {
+    AuthorID        uuid.NullUUID
+    // Only chirps written by users that this user follows
+    FollowedBy      uuid.NullUUID
+    // Inclusive lower bound on created_at
+    Since           sql.NullTime
+    // Exclusive upper bound on created_at
+    Until           sql.NullTime
+    // Case-insensitive substring match on the body
+    Contains        string
+    // Normalized hashtag (lowercase, without the #)
+    Hashtag         string
+    Descending      bool
+    // Keyset cursor on (created_at, id). Only rows that come after the cursor in the sort direction
+    // are returned.
+    CursorCreatedAt sql.NullTime
+    CursorID        uuid.UUID
+    // 0 means no limit
+    Limit           int32
+}
+
+type queryBuilder struct {
+    conditions []string
+    args []any
+}
+
+// Add a condition to the WHERE clause. Each ? in the condition is replaced by a positional parameter
+// bound to the matching arg.
+func (builder *queryBuilder) where(condition string, args ...any) {
+    var sb strings.Builder
+    argIndex := 0
+    for _, char := range condition {
+        if char == '?' && argIndex < len(args) {
+            builder.args = append(builder.args, args[argIndex])
+            fmt.Fprintf(&sb, "$%d", len(builder.args))
+            argIndex += 1
+            continue
+        }
+        sb.WriteRune(char)
+    }
+    builder.conditions = append(builder.conditions, sb.String())
+}
+
+func (builder *queryBuilder) whereClause() string {
+    if len(builder.conditions) == 0 { return "" }
+    return " WHERE " + strings.Join(builder.conditions, " AND ")
+}
+
+// Escape LIKE wildcards so user input only ever matches literally
+func escapeLikePattern(pattern string) string {
+    replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+    return replacer.Replace(pattern)
+}
+
+func buildListChirpsQuery(params ListChirpsParams) (string, []any) {
+    var builder queryBuilder
+
+    if params.AuthorID.Valid { builder.where("user_id = ?", params.AuthorID.UUID) }
+    if params.FollowedBy.Valid {
+        builder.where("user_id IN (SELECT followee_id FROM follows WHERE follower_id = ?)", params.FollowedBy.UUID)
+    }
+    if params.Since.Valid { builder.where("created_at >= ?", params.Since.Time) }
+    if params.Until.Valid { builder.where("created_at < ?", params.Until.Time) }
+    if params.Contains != "" {
+        builder.where(`body ILIKE '%' || ? || '%' ESCAPE '\'`, escapeLikePattern(params.Contains))
+    }
+    if params.Hashtag != "" {
+        builder.where(
+            "id IN (SELECT ch.chirp_id FROM chirp_hashtags ch INNER JOIN hashtags h ON ch.hashtag_id = h.id WHERE h.tag = ?)",
+            params.Hashtag,
+        )
+    }
+
+    direction := "ASC"
+    comparison := ">"
+    if params.Descending {
+        direction = "DESC"
+        comparison = "<"
+    }
+    if params.CursorCreatedAt.Valid {
+        builder.where(
+            fmt.Sprintf("(created_at, id) %s (?, ?)", comparison),
+            params.CursorCreatedAt.Time,
+            params.CursorID,
+        )
+    }
+
+    query := fmt.Sprintf(
+        "SELECT %s FROM chirps%s ORDER BY created_at %s, id %s",
+        chirpColumns,
+        builder.whereClause(),
+        direction,
+        direction,
+    )
+    if params.Limit > 0 {
+        builder.args = append(builder.args, params.Limit)
+        query += fmt.Sprintf(" LIMIT $%d", len(builder.args))
+    }
+
+    return query, builder.args
+}
+
+func (q *Queries) ListChirps(ctx context.Context, params ListChirpsParams) ([]Chirp, error) {
+    query, args := buildListChirpsQuery(params)
+    rows, err := q.db.QueryContext(ctx, query, args...)
+    if err != nil { return nil, err }
+    defer rows.Close()
+
+    var items []Chirp
+    for rows.Next() {
+        var i Chirp
+        if err := rows.Scan(
+            &i.ID,
+            &i.CreatedAt,
+            &i.UpdatedAt,
+            &i.Body,
+            &i.UserID,
+            &i.EditedAt,
+            &i.ParentID,
+            &i.RechirpOf,
+            &i.QuoteOf,
+        ); err != nil {
+            return nil, err
+        }
+        items = append(items, i)
+    }
+    if err := rows.Close(); err != nil { return nil, err }
+    if err := rows.Err(); err != nil { return nil, err }
+
+    return items, nil
+}
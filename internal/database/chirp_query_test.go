@@ -0,0 +1,97 @@
+package database
+
+import (
+    "testing"
+    "time"
+    "reflect"
+    "database/sql"
+    "github.com/google/uuid"
+)
+
+func TestBuildListChirpsQuery(t *testing.T) {
+    authorId := uuid.New()
+    followerId := uuid.New()
+    cursorId := uuid.New()
+    since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    until := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+    testCases := []struct {
+        in ListChirpsParams
+        expectedQuery string
+        expectedArgs []any
+    }{
+        {
+            in: ListChirpsParams {},
+            expectedQuery: "SELECT " + chirpColumns + " FROM chirps ORDER BY created_at ASC, id ASC",
+            expectedArgs: nil,
+        },
+        {
+            in: ListChirpsParams { AuthorID: uuid.NullUUID { UUID: authorId, Valid: true }, Descending: true },
+            expectedQuery: "SELECT " + chirpColumns + " FROM chirps WHERE user_id = $1 ORDER BY created_at DESC, id DESC",
+            expectedArgs: []any { authorId },
+        },
+        {
+            in: ListChirpsParams { FollowedBy: uuid.NullUUID { UUID: followerId, Valid: true }, Descending: true },
+            expectedQuery: "SELECT " + chirpColumns + " FROM chirps" +
+                " WHERE user_id IN (SELECT followee_id FROM follows WHERE follower_id = $1)" +
+                " ORDER BY created_at DESC, id DESC",
+            expectedArgs: []any { followerId },
+        },
+        {
+            in: ListChirpsParams { AuthorID: uuid.NullUUID { UUID: authorId, Valid: true }, Hashtag: "golang" },
+            expectedQuery: "SELECT " + chirpColumns + " FROM chirps" +
+                " WHERE user_id = $1" +
+                " AND id IN (SELECT ch.chirp_id FROM chirp_hashtags ch INNER JOIN hashtags h ON ch.hashtag_id = h.id WHERE h.tag = $2)" +
+                " ORDER BY created_at ASC, id ASC",
+            expectedArgs: []any { authorId, "golang" },
+        },
+        {
+            in: ListChirpsParams {
+                Since: sql.NullTime { Time: since, Valid: true },
+                Until: sql.NullTime { Time: until, Valid: true },
+                Contains: "50%_off",
+                Limit: 10,
+            },
+            expectedQuery: "SELECT " + chirpColumns + " FROM chirps" +
+                " WHERE created_at >= $1 AND created_at < $2 AND body ILIKE '%' || $3 || '%' ESCAPE '\\'" +
+                " ORDER BY created_at ASC, id ASC LIMIT $4",
+            expectedArgs: []any { since, until, `50\%\_off`, int32(10) },
+        },
+        {
+            in: ListChirpsParams {
+                AuthorID: uuid.NullUUID { UUID: authorId, Valid: true },
+                Descending: true,
+                CursorCreatedAt: sql.NullTime { Time: since, Valid: true },
+                CursorID: cursorId,
+                Limit: 21,
+            },
+            expectedQuery: "SELECT " + chirpColumns + " FROM chirps" +
+                " WHERE user_id = $1 AND (created_at, id) < ($2, $3)" +
+                " ORDER BY created_at DESC, id DESC LIMIT $4",
+            expectedArgs: []any { authorId, since, cursorId, int32(21) },
+        },
+    }
+
+    for i := range testCases {
+        testCase := testCases[i]
+        query, args := buildListChirpsQuery(testCase.in)
+
+        if query != testCase.expectedQuery {
+            t.Errorf(
+                "Test case %v: unexpected query\nactual:   %v\nexpected: %v\n",
+                i,
+                query,
+                testCase.expectedQuery,
+            )
+        }
+
+        if !reflect.DeepEqual(args, testCase.expectedArgs) {
+            t.Errorf(
+                "Test case %v: unexpected args (actual %v != expected %v)\n",
+                i,
+                args,
+                testCase.expectedArgs,
+            )
+        }
+    }
+}
@@ -7,23 +7,29 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, user_id, body)
-VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2)
-RETURNING id, created_at, updated_at, body, user_id
+INSERT INTO chirps (id, created_at, updated_at, user_id, body, parent_id, rechirp_of, quote_of)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4, $5)
+RETURNING id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of
 `
 
 type CreateChirpParams struct {
-	UserID uuid.UUID `json:"user_id"`
-	Body   string    `json:"body"`
+	UserID    uuid.UUID     `json:"user_id"`
+	Body      string        `json:"body"`
+	ParentID  uuid.NullUUID `json:"parent_id"`
+	RechirpOf uuid.NullUUID `json:"rechirp_of"`
+	QuoteOf   uuid.NullUUID `json:"quote_of"`
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.UserID, arg.Body)
+	row := q.db.QueryRowContext(ctx, createChirp, arg.UserID, arg.Body, arg.ParentID, arg.RechirpOf, arg.QuoteOf)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -31,6 +37,10 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.EditedAt,
+		&i.ParentID,
+		&i.RechirpOf,
+		&i.QuoteOf,
 	)
 	return i, err
 }
@@ -46,10 +56,29 @@ func (q *Queries) DeleteChirp(ctx context.Context, id uuid.UUID) (interface{}, e
 	return column_1, err
 }
 
+const deleteRechirp = `-- name: DeleteRechirp :execrows
+DELETE FROM chirps WHERE user_id = $1 AND rechirp_of = $2
+`
+
+type DeleteRechirpParams struct {
+	UserID    uuid.UUID     `json:"user_id"`
+	RechirpOf uuid.NullUUID `json:"rechirp_of"`
+}
+
+func (q *Queries) DeleteRechirp(ctx context.Context, arg DeleteRechirpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteRechirp, arg.UserID, arg.RechirpOf)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getChirp = `-- name: GetChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1
+
+SELECT id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of FROM chirps WHERE id = $1
 `
 
+// Chirp listings are built dynamically by ListChirps in internal/database/chirp_query.go
 func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 	row := q.db.QueryRowContext(ctx, getChirp, id)
 	var i Chirp
@@ -59,32 +88,66 @@ func (q *Queries) GetChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.EditedAt,
+		&i.ParentID,
+		&i.RechirpOf,
+		&i.QuoteOf,
 	)
 	return i, err
 }
 
-const getChirps = `-- name: GetChirps :many
-
-SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at ASC
+const getChirpAncestors = `-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of, 1 AS depth
+    FROM chirps
+    WHERE chirps.id = (SELECT c.parent_id FROM chirps c WHERE c.id = $1)
+    UNION ALL
+    SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.edited_at, c.parent_id, c.rechirp_of, c.quote_of, a.depth + 1
+    FROM chirps c INNER JOIN ancestors a ON c.id = a.parent_id
+    WHERE a.depth < $2::int
+)
+SELECT id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of, depth FROM ancestors ORDER BY depth ASC
 `
 
-// TODO figure out a way to collate GetChirps<sortorder> and GetUserChirps<sortorder>
-// might need to shift away from sqlc to something that allows for dynamic query generation
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+type GetChirpAncestorsParams struct {
+	ID       uuid.UUID `json:"id"`
+	MaxDepth int32     `json:"max_depth"`
+}
+
+type GetChirpAncestorsRow struct {
+	ID        uuid.UUID     `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Body      string        `json:"body"`
+	UserID    uuid.UUID     `json:"user_id"`
+	EditedAt  sql.NullTime  `json:"edited_at"`
+	ParentID  uuid.NullUUID `json:"parent_id"`
+	RechirpOf uuid.NullUUID `json:"rechirp_of"`
+	QuoteOf   uuid.NullUUID `json:"quote_of"`
+	Depth     int32         `json:"depth"`
+}
+
+// Walks up the reply chain from a chirp, nearest parent first
+func (q *Queries) GetChirpAncestors(ctx context.Context, arg GetChirpAncestorsParams) ([]GetChirpAncestorsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpAncestors, arg.ID, arg.MaxDepth)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Chirp
+	var items []GetChirpAncestorsRow
 	for rows.Next() {
-		var i Chirp
+		var i GetChirpAncestorsRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.EditedAt,
+			&i.ParentID,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.Depth,
 		); err != nil {
 			return nil, err
 		}
@@ -99,25 +162,62 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getChirpsDesc = `-- name: GetChirpsDesc :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at DESC
+const getChirpDescendants = `-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of, 1 AS depth
+    FROM chirps
+    WHERE chirps.parent_id = $1::uuid
+    UNION ALL
+    SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.edited_at, c.parent_id, c.rechirp_of, c.quote_of, d.depth + 1
+    FROM chirps c INNER JOIN descendants d ON c.parent_id = d.id
+    WHERE d.depth < $2::int
+)
+SELECT id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of, depth FROM descendants
+ORDER BY depth ASC, created_at ASC, id ASC
+LIMIT $3
 `
 
-func (q *Queries) GetChirpsDesc(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsDesc)
+type GetChirpDescendantsParams struct {
+	ID         uuid.UUID `json:"id"`
+	MaxDepth   int32     `json:"max_depth"`
+	MaxReplies int32     `json:"max_replies"`
+}
+
+type GetChirpDescendantsRow struct {
+	ID        uuid.UUID     `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Body      string        `json:"body"`
+	UserID    uuid.UUID     `json:"user_id"`
+	EditedAt  sql.NullTime  `json:"edited_at"`
+	ParentID  uuid.NullUUID `json:"parent_id"`
+	RechirpOf uuid.NullUUID `json:"rechirp_of"`
+	QuoteOf   uuid.NullUUID `json:"quote_of"`
+	Depth     int32         `json:"depth"`
+}
+
+// Breadth-first walk down the replies to a chirp. Rows are ordered so a reply always comes after the
+// chirp it replied to.
+func (q *Queries) GetChirpDescendants(ctx context.Context, arg GetChirpDescendantsParams) ([]GetChirpDescendantsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpDescendants, arg.ID, arg.MaxDepth, arg.MaxReplies)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Chirp
+	var items []GetChirpDescendantsRow
 	for rows.Next() {
-		var i Chirp
+		var i GetChirpDescendantsRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.EditedAt,
+			&i.ParentID,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.Depth,
 		); err != nil {
 			return nil, err
 		}
@@ -132,12 +232,44 @@ func (q *Queries) GetChirpsDesc(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getUserChirps = `-- name: GetUserChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE user_id = $1 ORDER BY created_at ASC
+const getChirpRevisions = `-- name: GetChirpRevisions :many
+SELECT id, created_at, chirp_id, body FROM chirp_revisions WHERE chirp_id = $1 ORDER BY created_at ASC
 `
 
-func (q *Queries) GetUserChirps(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getUserChirps, userID)
+func (q *Queries) GetChirpRevisions(ctx context.Context, chirpID uuid.UUID) ([]ChirpRevision, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpRevisions, chirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ChirpRevision
+	for rows.Next() {
+		var i ChirpRevision
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.ChirpID,
+			&i.Body,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsByIds = `-- name: GetChirpsByIds :many
+SELECT id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of FROM chirps WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) GetChirpsByIds(ctx context.Context, ids []uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByIds, pq.Array(ids))
 	if err != nil {
 		return nil, err
 	}
@@ -151,6 +283,10 @@ func (q *Queries) GetUserChirps(ctx context.Context, userID uuid.UUID) ([]Chirp,
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.EditedAt,
+			&i.ParentID,
+			&i.RechirpOf,
+			&i.QuoteOf,
 		); err != nil {
 			return nil, err
 		}
@@ -165,25 +301,64 @@ func (q *Queries) GetUserChirps(ctx context.Context, userID uuid.UUID) ([]Chirp,
 	return items, nil
 }
 
-const getUserChirpsDesc = `-- name: GetUserChirpsDesc :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE user_id = $1 ORDER BY created_at DESC
+const searchChirps = `-- name: SearchChirps :many
+SELECT
+    id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of,
+    ts_rank(to_tsvector('english', body), websearch_to_tsquery('english', $1))::real AS rank,
+    ts_headline(
+        'english',
+        translate(body, E'\x02\x03', ''),
+        websearch_to_tsquery('english', $1),
+        E'StartSel=\x02, StopSel=\x03'
+    )::text AS snippet
+FROM chirps
+WHERE to_tsvector('english', body) @@ websearch_to_tsquery('english', $1)
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT $2 OFFSET $3
 `
 
-func (q *Queries) GetUserChirpsDesc(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getUserChirpsDesc, userID)
+type SearchChirpsParams struct {
+	Query      string `json:"query"`
+	PageLimit  int32  `json:"page_limit"`
+	PageOffset int32  `json:"page_offset"`
+}
+
+type SearchChirpsRow struct {
+	ID        uuid.UUID     `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Body      string        `json:"body"`
+	UserID    uuid.UUID     `json:"user_id"`
+	EditedAt  sql.NullTime  `json:"edited_at"`
+	ParentID  uuid.NullUUID `json:"parent_id"`
+	RechirpOf uuid.NullUUID `json:"rechirp_of"`
+	QuoteOf   uuid.NullUUID `json:"quote_of"`
+	Rank      float32       `json:"rank"`
+	Snippet   string        `json:"snippet"`
+}
+
+// Matches are wrapped in \x02 and \x03 in the snippet, which are taken out of the body first so they can't be faked
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps, arg.Query, arg.PageLimit, arg.PageOffset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Chirp
+	var items []SearchChirpsRow
 	for rows.Next() {
-		var i Chirp
+		var i SearchChirpsRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.EditedAt,
+			&i.ParentID,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.Rank,
+			&i.Snippet,
 		); err != nil {
 			return nil, err
 		}
@@ -197,3 +372,38 @@ func (q *Queries) GetUserChirpsDesc(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+WITH revision AS (
+    INSERT INTO chirp_revisions (id, created_at, chirp_id, body)
+    SELECT gen_random_uuid(), NOW(), id, body FROM chirps WHERE id = $1
+)
+UPDATE chirps
+SET body = $2, edited_at = NOW(), updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of
+`
+
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID `json:"id"`
+	Body string    `json:"body"`
+}
+
+// The old body is saved as a revision in the same statement. Both parts see the same snapshot of the
+// table, so the revision always gets the body from before the update.
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.EditedAt,
+		&i.ParentID,
+		&i.RechirpOf,
+		&i.QuoteOf,
+	)
+	return i, err
+}
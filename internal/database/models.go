@@ -11,28 +11,140 @@ import (
 	"github.com/google/uuid"
 )
 
+type ApiKey struct {
+	ID         uuid.UUID    `json:"id"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UserID     uuid.UUID    `json:"user_id"`
+	Name       string       `json:"name"`
+	KeyHash    string       `json:"key_hash"`
+	KeyPrefix  string       `json:"key_prefix"`
+	Scopes     []string     `json:"scopes"`
+	LastUsedAt sql.NullTime `json:"last_used_at"`
+}
+
 type Chirp struct {
+	ID        uuid.UUID     `json:"id"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Body      string        `json:"body"`
+	UserID    uuid.UUID     `json:"user_id"`
+	EditedAt  sql.NullTime  `json:"edited_at"`
+	ParentID  uuid.NullUUID `json:"parent_id"`
+	RechirpOf uuid.NullUUID `json:"rechirp_of"`
+	QuoteOf   uuid.NullUUID `json:"quote_of"`
+}
+
+type ChirpHashtag struct {
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	HashtagID uuid.UUID `json:"hashtag_id"`
+}
+
+type ChirpLike struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ChirpRevision struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ChirpID   uuid.UUID `json:"chirp_id"`
 	Body      string    `json:"body"`
+}
+
+type EmailVerificationToken struct {
+	ID        uuid.UUID    `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UserID    uuid.UUID    `json:"user_id"`
+	Email     string       `json:"email"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	UsedAt    sql.NullTime `json:"used_at"`
+}
+
+type Follow struct {
+	FollowerID uuid.UUID `json:"follower_id"`
+	FolloweeID uuid.UUID `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Hashtag struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Tag       string    `json:"tag"`
+}
+
+type LoginThrottle struct {
+	Key           string    `json:"key"`
+	Failures      int32     `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+type Mention struct {
+	ChirpID   uuid.UUID `json:"chirp_id"`
 	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
-type RefreshToken struct {
-	Token     string       `json:"token"`
+type MfaRecoveryCode struct {
+	CodeHash  string       `json:"code_hash"`
+	CreatedAt time.Time    `json:"created_at"`
+	UserID    uuid.UUID    `json:"user_id"`
+	UsedAt    sql.NullTime `json:"used_at"`
+}
+
+type Notification struct {
+	ID        uuid.UUID    `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UserID    uuid.UUID    `json:"user_id"`
+	ActorID   uuid.UUID    `json:"actor_id"`
+	Kind      string       `json:"kind"`
+	ChirpID   uuid.UUID    `json:"chirp_id"`
+	ReadAt    sql.NullTime `json:"read_at"`
+}
+
+type PasswordResetToken struct {
+	TokenHash string       `json:"token_hash"`
 	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt time.Time    `json:"updated_at"`
 	UserID    uuid.UUID    `json:"user_id"`
 	ExpiresAt time.Time    `json:"expires_at"`
-	RevokedAt sql.NullTime `json:"revoked_at"`
+	UsedAt    sql.NullTime `json:"used_at"`
+}
+
+type RefreshToken struct {
+	CreatedAt        time.Time    `json:"created_at"`
+	UpdatedAt        time.Time    `json:"updated_at"`
+	UserID           uuid.UUID    `json:"user_id"`
+	ExpiresAt        time.Time    `json:"expires_at"`
+	RevokedAt        sql.NullTime `json:"revoked_at"`
+	FamilyID         uuid.UUID    `json:"family_id"`
+	ID               uuid.UUID    `json:"id"`
+	TokenHash        string       `json:"token_hash"`
+	SessionCreatedAt time.Time    `json:"session_created_at"`
+	UserAgent        string       `json:"user_agent"`
+	Ip               string       `json:"ip"`
+	RotatedAt        sql.NullTime `json:"rotated_at"`
+}
+
+type UsedMfaChallenge struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type User struct {
-	ID             uuid.UUID `json:"id"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-	Email          string    `json:"email"`
-	HashedPassword string    `json:"hashed_password"`
-	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	ID               uuid.UUID      `json:"id"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	Email            string         `json:"email"`
+	HashedPassword   string         `json:"hashed_password"`
+	IsChirpyRed      bool           `json:"is_chirpy_red"`
+	Handle           sql.NullString `json:"handle"`
+	DisplayName      string         `json:"display_name"`
+	Bio              string         `json:"bio"`
+	Website          string         `json:"website"`
+	EmailVerifiedAt  sql.NullTime   `json:"email_verified_at"`
+	TotpSecret       sql.NullString `json:"totp_secret"`
+	TotpEnabledAt    sql.NullTime   `json:"totp_enabled_at"`
+	TotpLastUsedStep sql.NullInt64  `json:"totp_last_used_step"`
 }
@@ -13,57 +13,76 @@ import (
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
-VALUES ($1, NOW(), NOW(), $2, $3, NULL)
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+INSERT INTO refresh_tokens (
+    id, token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, session_created_at, user_agent, ip
+)
+VALUES (gen_random_uuid(), $1, NOW(), NOW(), $2, $3, NULL, $4, $5, $6, $7)
+RETURNING created_at, updated_at, user_id, expires_at, revoked_at, family_id, id, token_hash, session_created_at, user_agent, ip, rotated_at
 `
 
 type CreateRefreshTokenParams struct {
-	Token     string    `json:"token"`
-	UserID    uuid.UUID `json:"user_id"`
-	ExpiresAt time.Time `json:"expires_at"`
+	TokenHash        string    `json:"token_hash"`
+	UserID           uuid.UUID `json:"user_id"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	FamilyID         uuid.UUID `json:"family_id"`
+	SessionCreatedAt time.Time `json:"session_created_at"`
+	UserAgent        string    `json:"user_agent"`
+	Ip               string    `json:"ip"`
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.TokenHash, arg.UserID, arg.ExpiresAt, arg.FamilyID, arg.SessionCreatedAt, arg.UserAgent, arg.Ip)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ID,
+		&i.TokenHash,
+		&i.SessionCreatedAt,
+		&i.UserAgent,
+		&i.Ip,
+		&i.RotatedAt,
 	)
 	return i, err
 }
 
 const getRefreshToken = `-- name: GetRefreshToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens WHERE token = $1
+SELECT created_at, updated_at, user_id, expires_at, revoked_at, family_id, id, token_hash, session_created_at, user_agent, ip, rotated_at FROM refresh_tokens WHERE token_hash = $1
 `
 
-func (q *Queries) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getRefreshToken, token)
+// Refresh tokens are looked up by the sha256 hash of the token the client sent
+func (q *Queries) GetRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshToken, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ID,
+		&i.TokenHash,
+		&i.SessionCreatedAt,
+		&i.UserAgent,
+		&i.Ip,
+		&i.RotatedAt,
 	)
 	return i, err
 }
 
 const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
-SELECT u.id, u.created_at, u.updated_at, u.email, u.hashed_password, u.is_chirpy_red
+SELECT u.id, u.created_at, u.updated_at, u.email, u.hashed_password, u.is_chirpy_red, u.handle, u.display_name, u.bio, u.website, u.email_verified_at, u.totp_secret, u.totp_enabled_at, u.totp_last_used_step
 FROM refresh_tokens r INNER JOIN users u ON r.user_id = u.id
-WHERE r.token = $1
+WHERE r.token_hash = $1
 `
 
-func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, token)
+func (q *Queries) GetUserFromRefreshToken(ctx context.Context, tokenHash string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, tokenHash)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -72,6 +91,131 @@ func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (Us
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Website,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastUsedStep,
+	)
+	return i, err
+}
+
+const getUserRefreshTokens = `-- name: GetUserRefreshTokens :many
+SELECT created_at, updated_at, user_id, expires_at, revoked_at, family_id, id, token_hash, session_created_at, user_agent, ip, rotated_at FROM refresh_tokens WHERE user_id = $1 ORDER BY created_at ASC
+`
+
+func (q *Queries) GetUserRefreshTokens(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	rows, err := q.db.QueryContext(ctx, getUserRefreshTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefreshToken
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.FamilyID,
+			&i.ID,
+			&i.TokenHash,
+			&i.SessionCreatedAt,
+			&i.UserAgent,
+			&i.Ip,
+			&i.RotatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserSessions = `-- name: GetUserSessions :many
+SELECT created_at, updated_at, user_id, expires_at, revoked_at, family_id, id, token_hash, session_created_at, user_agent, ip, rotated_at FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+ORDER BY created_at DESC
+`
+
+type GetUserSessionsParams struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Only the newest token of a family is ever active, so this is one row per session
+func (q *Queries) GetUserSessions(ctx context.Context, arg GetUserSessionsParams) ([]RefreshToken, error) {
+	rows, err := q.db.QueryContext(ctx, getUserSessions, arg.UserID, arg.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefreshToken
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UserID,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.FamilyID,
+			&i.ID,
+			&i.TokenHash,
+			&i.SessionCreatedAt,
+			&i.UserAgent,
+			&i.Ip,
+			&i.RotatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeActiveRefreshToken = `-- name: RevokeActiveRefreshToken :one
+UPDATE refresh_tokens
+SET revoked_at = NOW(), rotated_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND revoked_at IS NULL
+RETURNING created_at, updated_at, user_id, expires_at, revoked_at, family_id, id, token_hash, session_created_at, user_agent, ip, rotated_at
+`
+
+// Revokes a token that's being exchanged for a new one. Only revokes the token if it wasn't already, so
+// when the same token is used twice at the same time just one of the uses gets a row back.
+func (q *Queries) RevokeActiveRefreshToken(ctx context.Context, id uuid.UUID) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, revokeActiveRefreshToken, id)
+	var i RefreshToken
+	err := row.Scan(
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ID,
+		&i.TokenHash,
+		&i.SessionCreatedAt,
+		&i.UserAgent,
+		&i.Ip,
+		&i.RotatedAt,
 	)
 	return i, err
 }
@@ -79,20 +223,67 @@ func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (Us
 const revokeRefreshToken = `-- name: RevokeRefreshToken :one
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+WHERE token_hash = $1
+RETURNING created_at, updated_at, user_id, expires_at, revoked_at, family_id, id, token_hash, session_created_at, user_agent, ip, rotated_at
 `
 
-func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, revokeRefreshToken, token)
+func (q *Queries) RevokeRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, revokeRefreshToken, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ID,
+		&i.TokenHash,
+		&i.SessionCreatedAt,
+		&i.UserAgent,
+		&i.Ip,
+		&i.RotatedAt,
 	)
 	return i, err
 }
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
+const revokeUserRefreshTokens = `-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeUserRefreshTokens, userID)
+	return err
+}
+
+const revokeUserSession = `-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeUserSessionParams struct {
+	UserID   uuid.UUID `json:"user_id"`
+	FamilyID uuid.UUID `json:"family_id"`
+}
+
+func (q *Queries) RevokeUserSession(ctx context.Context, arg RevokeUserSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeUserSession, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -7,23 +7,26 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
-VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2)
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, $3)
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, display_name, bio, website, email_verified_at, totp_secret, totp_enabled_at, totp_last_used_step
 `
 
 type CreateUserParams struct {
-	Email          string `json:"email"`
-	HashedPassword string `json:"hashed_password"`
+	Email          string         `json:"email"`
+	HashedPassword string         `json:"hashed_password"`
+	Handle         sql.NullString `json:"handle"`
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword, arg.Handle)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -32,12 +35,90 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Website,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastUsedStep,
+	)
+	return i, err
+}
+
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1
+`
+
+// Everything else belonging to the user goes with them through ON DELETE CASCADE
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const disableTotp = `-- name: DisableTotp :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_used_step = NULL, updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) DisableTotp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, disableTotp, id)
+	return err
+}
+
+const enableTotp = `-- name: EnableTotp :execrows
+UPDATE users
+SET totp_enabled_at = NOW(), totp_last_used_step = $2, updated_at = NOW()
+WHERE id = $1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL
+`
+
+type EnableTotpParams struct {
+	ID               uuid.UUID     `json:"id"`
+	TotpLastUsedStep sql.NullInt64 `json:"totp_last_used_step"`
+}
+
+// The step of the code used to confirm is recorded so that code can't also be used to log in
+func (q *Queries) EnableTotp(ctx context.Context, arg EnableTotpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, enableTotp, arg.ID, arg.TotpLastUsedStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, display_name, bio, website, email_verified_at, totp_secret, totp_enabled_at, totp_last_used_step FROM users WHERE id = $1
+`
+
+func (q *Queries) GetUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Website,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastUsedStep,
 	)
 	return i, err
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, display_name, bio, website, email_verified_at, totp_secret, totp_enabled_at, totp_last_used_step FROM users WHERE email = $1
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -50,10 +131,147 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Website,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastUsedStep,
 	)
 	return i, err
 }
 
+const getUserByHandle = `-- name: GetUserByHandle :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, display_name, bio, website, email_verified_at, totp_secret, totp_enabled_at, totp_last_used_step FROM users WHERE handle = $1
+`
+
+func (q *Queries) GetUserByHandle(ctx context.Context, handle sql.NullString) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByHandle, handle)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Website,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastUsedStep,
+	)
+	return i, err
+}
+
+const getUsersByHandles = `-- name: GetUsersByHandles :many
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, display_name, bio, website, email_verified_at, totp_secret, totp_enabled_at, totp_last_used_step FROM users WHERE handle = ANY($1::text[])
+`
+
+func (q *Queries) GetUsersByHandles(ctx context.Context, handles []string) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersByHandles, pq.Array(handles))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.IsChirpyRed,
+			&i.Handle,
+			&i.DisplayName,
+			&i.Bio,
+			&i.Website,
+			&i.EmailVerifiedAt,
+			&i.TotpSecret,
+			&i.TotpEnabledAt,
+			&i.TotpLastUsedStep,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUsersByIds = `-- name: GetUsersByIds :many
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, display_name, bio, website, email_verified_at, totp_secret, totp_enabled_at, totp_last_used_step FROM users WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) GetUsersByIds(ctx context.Context, ids []uuid.UUID) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersByIds, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.IsChirpyRed,
+			&i.Handle,
+			&i.DisplayName,
+			&i.Bio,
+			&i.Website,
+			&i.EmailVerifiedAt,
+			&i.TotpSecret,
+			&i.TotpEnabledAt,
+			&i.TotpLastUsedStep,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const rehashUserPassword = `-- name: RehashUserPassword :exec
+UPDATE users
+SET hashed_password = $1
+WHERE id = $2 AND hashed_password = $3
+`
+
+type RehashUserPasswordParams struct {
+	NewHash string    `json:"new_hash"`
+	ID      uuid.UUID `json:"id"`
+	OldHash string    `json:"old_hash"`
+}
+
+// Only replaces the hash the password was checked against, so it can't undo a password change that
+// happened in the meantime
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, rehashUserPassword, arg.NewHash, arg.ID, arg.OldHash)
+	return err
+}
+
 const reset = `-- name: Reset :one
 DELETE FROM users RETURNING NULL
 `
@@ -65,21 +283,57 @@ func (q *Queries) Reset(ctx context.Context) (interface{}, error) {
 	return column_1, err
 }
 
+const startTotpEnrollment = `-- name: StartTotpEnrollment :execrows
+UPDATE users
+SET totp_secret = $2, totp_last_used_step = NULL, updated_at = NOW()
+WHERE id = $1 AND totp_enabled_at IS NULL
+`
+
+type StartTotpEnrollmentParams struct {
+	ID         uuid.UUID      `json:"id"`
+	TotpSecret sql.NullString `json:"totp_secret"`
+}
+
+// Replaces the secret of an enrollment that wasn't confirmed. Does nothing once two-factor auth is on.
+func (q *Queries) StartTotpEnrollment(ctx context.Context, arg StartTotpEnrollmentParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, startTotpEnrollment, arg.ID, arg.TotpSecret)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
-SET email = $2, hashed_password = $3
-WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+SET
+    email = COALESCE($1, email),
+    email_verified_at = CASE
+        WHEN $1 IS NULL OR $1 = email THEN email_verified_at
+        ELSE NULL
+    END,
+    hashed_password = COALESCE($2, hashed_password),
+    handle = COALESCE($3, handle),
+    display_name = COALESCE($4, display_name),
+    bio = COALESCE($5, bio),
+    website = COALESCE($6, website),
+    updated_at = NOW()
+WHERE id = $7
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, display_name, bio, website, email_verified_at, totp_secret, totp_enabled_at, totp_last_used_step
 `
 
 type UpdateUserParams struct {
-	ID             uuid.UUID `json:"id"`
-	Email          string    `json:"email"`
-	HashedPassword string    `json:"hashed_password"`
+	Email          sql.NullString `json:"email"`
+	HashedPassword sql.NullString `json:"hashed_password"`
+	Handle         sql.NullString `json:"handle"`
+	DisplayName    sql.NullString `json:"display_name"`
+	Bio            sql.NullString `json:"bio"`
+	Website        sql.NullString `json:"website"`
+	ID             uuid.UUID      `json:"id"`
 }
 
+// Only the fields that aren't NULL are changed. Changing the email means it has to be verified again.
 func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUser, arg.ID, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, updateUser, arg.Email, arg.HashedPassword, arg.Handle, arg.DisplayName, arg.Bio, arg.Website, arg.ID)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -88,6 +342,14 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Website,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastUsedStep,
 	)
 	return i, err
 }
@@ -96,7 +358,7 @@ const upgradeUserToChirpyRed = `-- name: UpgradeUserToChirpyRed :one
 UPDATE users
 SET is_chirpy_red = true
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, display_name, bio, website, email_verified_at, totp_secret, totp_enabled_at, totp_last_used_step
 `
 
 func (q *Queries) UpgradeUserToChirpyRed(ctx context.Context, id uuid.UUID) (User, error) {
@@ -109,6 +371,70 @@ func (q *Queries) UpgradeUserToChirpyRed(ctx context.Context, id uuid.UUID) (Use
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Website,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastUsedStep,
+	)
+	return i, err
+}
+
+const useTotpStep = `-- name: UseTotpStep :execrows
+UPDATE users
+SET totp_last_used_step = $2
+WHERE id = $1 AND (totp_last_used_step IS NULL OR totp_last_used_step < $2)
+`
+
+type UseTotpStepParams struct {
+	ID               uuid.UUID     `json:"id"`
+	TotpLastUsedStep sql.NullInt64 `json:"totp_last_used_step"`
+}
+
+// Only succeeds for a later step than the last one used, so a code works once even if it's sent twice
+// at the same time
+func (q *Queries) UseTotpStep(ctx context.Context, arg UseTotpStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useTotpStep, arg.ID, arg.TotpLastUsedStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const verifyUserEmail = `-- name: VerifyUserEmail :one
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, handle, display_name, bio, website, email_verified_at, totp_secret, totp_enabled_at, totp_last_used_step
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID `json:"id"`
+	Email string    `json:"email"`
+}
+
+// Does nothing if the email was changed after the verification was sent
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, verifyUserEmail, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Website,
+		&i.EmailVerifiedAt,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastUsedStep,
 	)
 	return i, err
 }
@@ -0,0 +1,80 @@
+package mail
+
+import (
+    "fmt"
+    "os"
+    "time"
+    "strings"
+    "net/smtp"
+    "path/filepath"
+)
+
+type Message struct {
+    To      string
+    Subject string
+    Body    string
+}
+
+// Anything that can deliver an email. The server only ever sends plain text messages.
+type Mailer interface {
+    Send(message Message) error
+}
+
+// Sends mail through an SMTP server, authenticating with PLAIN auth when a username is given
+type SmtpMailer struct {
+    Addr    string
+    Auth    smtp.Auth
+    From    string
+}
+
+func NewSmtpMailer(host, port, username, password, from string) *SmtpMailer {
+    mailer := SmtpMailer { Addr: host + ":" + port, From: from }
+    if username != "" { mailer.Auth = smtp.PlainAuth("", username, password, host) }
+    return &mailer
+}
+
+func (mailer *SmtpMailer) Send(message Message) error {
+    data, err := FormatMessage(mailer.From, message, time.Now())
+    if err != nil { return err }
+    return smtp.SendMail(mailer.Addr, mailer.Auth, mailer.From, []string { message.To }, data)
+}
+
+// For development. Writes each message to its own file in Dir, or prints it to stdout when Dir is empty.
+type LogMailer struct {
+    Dir     string
+    From    string
+}
+
+func (mailer *LogMailer) Send(message Message) error {
+    now := time.Now()
+    data, err := FormatMessage(mailer.From, message, now)
+    if err != nil { return err }
+
+    if mailer.Dir == "" {
+        fmt.Printf("Sending mail:\n%s\n", data)
+        return nil
+    }
+    if err := os.MkdirAll(mailer.Dir, 0755); err != nil { return err }
+    name := fmt.Sprintf("%d-%s.eml", now.UnixNano(), strings.ReplaceAll(message.To, string(filepath.Separator), "_"))
+    return os.WriteFile(filepath.Join(mailer.Dir, name), data, 0644)
+}
+
+// Build the raw RFC 5322 message. Header values can't contain line breaks, otherwise whoever controls
+// them could add their own headers.
+func FormatMessage(from string, message Message, date time.Time) ([]byte, error) {
+    for _, value := range []string { from, message.To, message.Subject } {
+        if strings.ContainsAny(value, "\r\n") { return nil, fmt.Errorf("mail headers can't contain line breaks") }
+    }
+
+    var builder strings.Builder
+    fmt.Fprintf(&builder, "From: %s\r\n", from)
+    fmt.Fprintf(&builder, "To: %s\r\n", message.To)
+    fmt.Fprintf(&builder, "Subject: %s\r\n", message.Subject)
+    fmt.Fprintf(&builder, "Date: %s\r\n", date.Format(time.RFC1123Z))
+    builder.WriteString("MIME-Version: 1.0\r\n")
+    builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+    builder.WriteString("\r\n")
+    // SMTP wants CRLF line endings in the body too
+    builder.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+    return []byte(builder.String()), nil
+}
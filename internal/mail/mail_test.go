@@ -0,0 +1,62 @@
+package mail
+
+import (
+    "testing"
+    "time"
+)
+
+func TestFormatMessage(t *testing.T) {
+    date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+    testCases := []struct {
+        from string
+        in Message
+        expectedOut string
+        shouldError bool
+    }{
+        {
+            from: "chirpy@example.com",
+            in: Message { To: "user@example.com", Subject: "Hello", Body: "line 1\nline 2" },
+            expectedOut: "From: chirpy@example.com\r\n" +
+                "To: user@example.com\r\n" +
+                "Subject: Hello\r\n" +
+                "Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n" +
+                "MIME-Version: 1.0\r\n" +
+                "Content-Type: text/plain; charset=UTF-8\r\n" +
+                "\r\n" +
+                "line 1\r\nline 2",
+            shouldError: false,
+        },
+        {
+            from: "chirpy@example.com",
+            in: Message { To: "user@example.com\r\nBcc: someone@example.com", Subject: "Hello", Body: "" },
+            expectedOut: "",
+            shouldError: true,
+        },
+        {
+            from: "chirpy@example.com",
+            in: Message { To: "user@example.com", Subject: "Hello\nBcc: someone@example.com", Body: "" },
+            expectedOut: "",
+            shouldError: true,
+        },
+    }
+
+    for i := range testCases {
+        testCase := testCases[i]
+        out, err := FormatMessage(testCase.from, testCase.in, date)
+
+        if !testCase.shouldError && err != nil {
+            t.Errorf("Test case %v: case errored but was not expected to: %v\n", i, err)
+            continue
+        }
+
+        if testCase.shouldError && err == nil {
+            t.Errorf("Test case %v: case did not error but was expected to\n", i)
+            continue
+        }
+
+        if string(out) != testCase.expectedOut {
+            t.Errorf("Test case %v: unexpected message\nactual:   %q\nexpected: %q\n", i, out, testCase.expectedOut)
+        }
+    }
+}
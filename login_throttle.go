@@ -0,0 +1,154 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "time"
+    "fmt"
+    "math"
+    "strings"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+)
+
+// Failed logins are counted per account and per ip address. Once either has more failures than it's
+// allowed, logging in is locked for a while, twice as long after every further failure.
+const LOGIN_ACCOUNT_FREE_FAILURES int32 = 5
+// Higher since many people can share an address
+const LOGIN_IP_FREE_FAILURES int32 = 20
+const LOGIN_BASE_LOCKOUT time.Duration = time.Second
+const LOGIN_MAX_LOCKOUT time.Duration = 15 * time.Minute
+// Failures are forgotten once there hasn't been another one for this long
+const LOGIN_FAILURE_WINDOW time.Duration = 24 * time.Hour
+
+type loginThrottleKeys struct {
+    account string
+    ip string
+}
+
+// The account is keyed by the email that was tried rather than the user, so emails without an account are
+// throttled exactly like ones with an account
+func getLoginThrottleKeys(email string, req *http.Request) loginThrottleKeys {
+    return loginThrottleKeys {
+        account: "account:" + strings.ToLower(strings.TrimSpace(email)),
+        ip: "ip:" + GetClientIp(req),
+    }
+}
+
+// Asking for reset emails is throttled in the same table under its own keys. Nothing ever clears them, every
+// request counts, so the free failures are how many emails can be asked for before having to wait.
+func getPasswordResetThrottleKeys(email string, req *http.Request) loginThrottleKeys {
+    keys := getLoginThrottleKeys(email, req)
+    return loginThrottleKeys { account: "reset:" + keys.account, ip: "reset:" + keys.ip }
+}
+
+func (keys loginThrottleKeys) getFreeFailures(key string) int32 {
+    if key == keys.account { return LOGIN_ACCOUNT_FREE_FAILURES }
+    return LOGIN_IP_FREE_FAILURES
+}
+
+// How long logging in is locked after the given number of failures
+func getLoginLockoutDuration(failures, freeFailures int32) time.Duration {
+    if failures <= freeFailures { return 0 }
+    // Capped before shifting so the duration can't overflow
+    return min(LOGIN_BASE_LOCKOUT << min(failures - freeFailures - 1, 20), LOGIN_MAX_LOCKOUT)
+}
+
+// Count one more failure, starting over if the last one was long enough ago
+func addLoginFailure(throttle database.LoginThrottle, freeFailures int32, now time.Time) database.LoginThrottle {
+    if throttle.LastFailureAt.Before(now.Add(-LOGIN_FAILURE_WINDOW)) { throttle.Failures = 0 }
+    throttle.Failures++
+    throttle.LastFailureAt = now
+    if lockout := getLoginLockoutDuration(throttle.Failures, freeFailures); lockout > 0 {
+        throttle.LockedUntil = now.Add(lockout)
+    }
+    return throttle
+}
+
+// Count an attempt as a failure before the password or code is checked, so requests sent at the same time
+// can't all get through before any of their failures are recorded. Returns how long the caller still has
+// to wait if they're locked out, in which case the attempt isn't counted.
+func (cfg *ApiConfig) startLoginAttempt(ctx context.Context, keys loginThrottleKeys) (time.Duration, error) {
+    now := dbNow()
+    var lockout time.Duration
+    err := cfg.WithTx(ctx, func(queries *database.Queries) error {
+        keyList := []string { keys.account, keys.ip }
+        createParams := database.CreateLoginThrottlesParams { Keys: keyList, CreatedAt: now }
+        if err := queries.CreateLoginThrottles(ctx, createParams); err != nil { return err }
+        throttles, err := queries.LockLoginThrottles(ctx, keyList)
+        if err != nil { return err }
+
+        for _, throttle := range throttles { lockout = max(lockout, throttle.LockedUntil.Sub(now)) }
+        if lockout > 0 { return nil }
+
+        for _, throttle := range throttles {
+            throttle = addLoginFailure(throttle, keys.getFreeFailures(throttle.Key), now)
+            params := database.UpdateLoginThrottleParams {
+                Key: throttle.Key,
+                Failures: throttle.Failures,
+                LastFailureAt: throttle.LastFailureAt,
+                LockedUntil: throttle.LockedUntil,
+            }
+            if err := queries.UpdateLoginThrottle(ctx, params); err != nil { return err }
+        }
+        return nil
+    })
+    return lockout, err
+}
+
+// Respond with 429 if the caller is locked out, otherwise count the attempt as a failure until
+// clearLoginFailures or forgiveLoginAttempt says it wasn't one. Returns whether the attempt may go ahead.
+func (cfg *ApiConfig) beginLoginAttempt(res http.ResponseWriter, req *http.Request, keys loginThrottleKeys) bool {
+    return cfg.beginThrottledAttempt(res, req, keys, "too many failed login attempts, try again later")
+}
+
+func (cfg *ApiConfig) beginThrottledAttempt(res http.ResponseWriter, req *http.Request, keys loginThrottleKeys, message string) bool {
+    lockout, err := cfg.startLoginAttempt(req.Context(), keys)
+    if err != nil {
+        // Failing closed would let a database hiccup lock everyone out, so just log it
+        fmt.Printf("Failed to start throttled attempt: %v\n", err)
+        return true
+    }
+    if lockout <= 0 { return true }
+
+    res.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(lockout.Seconds()))))
+    SendJsonErrorResponse(res, http.StatusTooManyRequests, message)
+    return false
+}
+
+// For attempts that weren't failures but didn't finish logging in either, like a right password when a
+// second factor is still needed
+func (cfg *ApiConfig) forgiveLoginAttempt(ctx context.Context, keys loginThrottleKeys) {
+    for _, key := range []string { keys.account, keys.ip } {
+        if err := cfg.Db.ForgiveLoginAttempt(ctx, key); err != nil {
+            fmt.Printf("Failed to forgive login attempt of %v: %v\n", key, err)
+        }
+    }
+}
+
+// Called once logging in succeeded. Only the account's failures are cleared, the address just gets this
+// attempt back. Clearing the address's too would let someone guessing at many accounts reset their count
+// by logging into their own.
+func (cfg *ApiConfig) clearLoginFailures(ctx context.Context, keys loginThrottleKeys) {
+    if err := cfg.Db.ClearLoginFailures(ctx, keys.account); err != nil {
+        fmt.Printf("Failed to clear login failures of %v: %v\n", keys.account, err)
+    }
+    if err := cfg.Db.ForgiveLoginAttempt(ctx, keys.ip); err != nil {
+        fmt.Printf("Failed to forgive login attempt of %v: %v\n", keys.ip, err)
+    }
+}
+
+// Check a password the user had to enter again to confirm a change to their account. Throttled exactly
+// like logging in, otherwise anyone holding an access token could guess the password here without limit.
+// Responds with 429 or 401 itself. Returns whether the password was right.
+func (cfg *ApiConfig) checkReenteredPassword(res http.ResponseWriter, req *http.Request, user database.User, password, message string) bool {
+    throttleKeys := getLoginThrottleKeys(user.Email, req)
+    if !cfg.beginLoginAttempt(res, req, throttleKeys) { return false }
+    // The attempt was already counted as a failure by beginLoginAttempt
+    if _, err := cfg.Passwords.Check(password, user.HashedPassword); err != nil {
+        SendJsonErrorResponse(res, http.StatusUnauthorized, message)
+        return false
+    }
+    cfg.clearLoginFailures(req.Context(), throttleKeys)
+    return true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "testing"
+    "time"
+    "net/http"
+    "net/http/httptest"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/database"
+)
+
+func TestLoginLockoutDuration(t *testing.T) {
+    type TestCase struct {
+        failures int32
+        freeFailures int32
+        expected time.Duration
+    }
+    testCases := []TestCase {
+        { failures: 0, freeFailures: 5, expected: 0 },
+        { failures: 5, freeFailures: 5, expected: 0 },
+        { failures: 6, freeFailures: 5, expected: LOGIN_BASE_LOCKOUT },
+        { failures: 7, freeFailures: 5, expected: 2 * LOGIN_BASE_LOCKOUT },
+        { failures: 10, freeFailures: 5, expected: 16 * LOGIN_BASE_LOCKOUT },
+        { failures: 21, freeFailures: 20, expected: LOGIN_BASE_LOCKOUT },
+        // 2^9 seconds is still under the cap, 2^10 isn't
+        { failures: 15, freeFailures: 5, expected: 512 * LOGIN_BASE_LOCKOUT },
+        { failures: 16, freeFailures: 5, expected: LOGIN_MAX_LOCKOUT },
+        // Would overflow without capping the shift
+        { failures: 1000, freeFailures: 5, expected: LOGIN_MAX_LOCKOUT },
+    }
+
+    for i, testCase := range testCases {
+        if actual := getLoginLockoutDuration(testCase.failures, testCase.freeFailures); actual != testCase.expected {
+            t.Errorf("Test case %v: expected a lockout of %v, got %v\n", i, testCase.expected, actual)
+        }
+    }
+}
+
+func TestAddLoginFailure(t *testing.T) {
+    now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+    earlierLock := now.Add(-time.Hour)
+
+    type TestCase struct {
+        throttle database.LoginThrottle
+        expectedFailures int32
+        expectedLockedUntil time.Time
+    }
+    testCases := []TestCase {
+        // New row from CreateLoginThrottles
+        {
+            throttle: database.LoginThrottle { Failures: 0, LastFailureAt: now, LockedUntil: now },
+            expectedFailures: 1,
+            expectedLockedUntil: now,
+        },
+        {
+            throttle: database.LoginThrottle { Failures: 4, LastFailureAt: now.Add(-time.Minute), LockedUntil: earlierLock },
+            expectedFailures: 5,
+            expectedLockedUntil: earlierLock,
+        },
+        {
+            throttle: database.LoginThrottle { Failures: 5, LastFailureAt: now.Add(-time.Minute), LockedUntil: earlierLock },
+            expectedFailures: 6,
+            expectedLockedUntil: now.Add(LOGIN_BASE_LOCKOUT),
+        },
+        {
+            throttle: database.LoginThrottle { Failures: 40, LastFailureAt: now.Add(-time.Minute), LockedUntil: earlierLock },
+            expectedFailures: 41,
+            expectedLockedUntil: now.Add(LOGIN_MAX_LOCKOUT),
+        },
+        // Still inside the window
+        {
+            throttle: database.LoginThrottle { Failures: 9, LastFailureAt: now.Add(-LOGIN_FAILURE_WINDOW), LockedUntil: earlierLock },
+            expectedFailures: 10,
+            expectedLockedUntil: now.Add(16 * LOGIN_BASE_LOCKOUT),
+        },
+        // The window passed so counting starts over
+        {
+            throttle: database.LoginThrottle { Failures: 9, LastFailureAt: now.Add(-LOGIN_FAILURE_WINDOW - time.Second), LockedUntil: earlierLock },
+            expectedFailures: 1,
+            expectedLockedUntil: earlierLock,
+        },
+    }
+
+    for i, testCase := range testCases {
+        actual := addLoginFailure(testCase.throttle, LOGIN_ACCOUNT_FREE_FAILURES, now)
+        if actual.Failures != testCase.expectedFailures {
+            t.Errorf("Test case %v: expected %v failures, got %v\n", i, testCase.expectedFailures, actual.Failures)
+        }
+        if !actual.LastFailureAt.Equal(now) {
+            t.Errorf("Test case %v: expected the last failure to be at %v, got %v\n", i, now, actual.LastFailureAt)
+        }
+        if !actual.LockedUntil.Equal(testCase.expectedLockedUntil) {
+            t.Errorf("Test case %v: expected to be locked until %v, got %v\n", i, testCase.expectedLockedUntil, actual.LockedUntil)
+        }
+    }
+}
+
+func TestPasswordResetThrottleKeys(t *testing.T) {
+    req := httptest.NewRequest(http.MethodPost, "/api/password/forgot", nil)
+    loginKeys := getLoginThrottleKeys(" User@Example.com", req)
+    resetKeys := getPasswordResetThrottleKeys("user@example.com ", req)
+
+    // Asking for reset emails mustn't use up login attempts or the other way around
+    if resetKeys.account == loginKeys.account || resetKeys.ip == loginKeys.ip {
+        t.Errorf("Reset keys %+v share a key with login keys %+v\n", resetKeys, loginKeys)
+    }
+    if resetKeys.account != "reset:" + loginKeys.account || resetKeys.ip != "reset:" + loginKeys.ip {
+        t.Errorf("Expected reset keys to be the login keys with a reset prefix, got %+v\n", resetKeys)
+    }
+    if resetKeys.getFreeFailures(resetKeys.account) != LOGIN_ACCOUNT_FREE_FAILURES || resetKeys.getFreeFailures(resetKeys.ip) != LOGIN_IP_FREE_FAILURES {
+        t.Error("Reset keys don't get the free failures of the key they were made from")
+    }
+}
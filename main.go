@@ -1,7 +1,7 @@
 package main
 
-import _ "github.com/lib/pq"
 import (
+    "context"
     "net/http"
     "sync/atomic"
     "encoding/json"
@@ -9,12 +9,18 @@ import (
     "os"
     "database/sql"
     "errors"
+    "strings"
+    "strconv"
+    "time"
 
     "github.com/joho/godotenv"
     "github.com/google/uuid"
+    "github.com/lib/pq"
+    "golang.org/x/crypto/bcrypt"
 
     "github.com/vedaRadev/chirpy-boot.dev/internal/database"
     "github.com/vedaRadev/chirpy-boot.dev/internal/auth"
+    "github.com/vedaRadev/chirpy-boot.dev/internal/mail"
 )
 
 func SendJsonErrorResponse(res http.ResponseWriter, code int, message string) {
@@ -37,19 +43,6 @@ func SendJsonResponse(res http.ResponseWriter, code int, data any) {
     res.Write(resBody)
 }
 
-func GetAuthenticatedUserId(header http.Header, secret string) (uuid.UUID, error, int) {
-    accessToken, err := auth.GetBearerToken(header)
-    if err != nil {
-        return uuid.UUID {}, err, http.StatusUnauthorized
-    }
-    userId, err := auth.ValidateJWT(accessToken, secret)
-    if err != nil {
-        return uuid.UUID {}, errors.New("failed to validate access token"), http.StatusUnauthorized
-    }
-
-    return userId, nil, 0
-}
-
 func DecodeRequestBodyParameters[T any](reqParams *T, res http.ResponseWriter, req *http.Request) (error, int) {
     if err := json.NewDecoder(req.Body).Decode(reqParams); err != nil {
         return errors.New("failed to decode request body"), http.StatusInternalServerError
@@ -58,14 +51,49 @@ func DecodeRequestBodyParameters[T any](reqParams *T, res http.ResponseWriter, r
     return nil, 0
 }
 
+// If err is postgres rejecting a duplicate value, get the name of the unique constraint it violated
+func GetUniqueViolation(err error) (string, bool) {
+    var pqErr *pq.Error
+    if errors.As(err, &pqErr) && pqErr.Code == "23505" { return pqErr.Constraint, true }
+    return "", false
+}
+
 type ApiConfig struct  {
     FileServerHits atomic.Int32
     Platform string
+    // Signs the links in emails. Access tokens are signed with Keys instead.
     Secret string
+    Keys *auth.KeySet
+    Passwords *auth.Passwords
     PolkaKey string
+    // Where the server is reachable from outside, used to build links in emails
+    BaseUrl string
+    RequireVerifiedEmail bool
+    Mailer mail.Mailer
+    DbConn *sql.DB
     Db *database.Queries
 }
 
+// Timestamps are stored without a time zone so every time handed to postgres has to be in UTC first
+func dbTime(t time.Time) time.Time {
+    return t.UTC()
+}
+
+func dbNow() time.Time {
+    return dbTime(time.Now())
+}
+
+// Run fn with queries that all belong to the same transaction. The transaction is committed if fn
+// succeeds and rolled back otherwise.
+func (cfg *ApiConfig) WithTx(ctx context.Context, fn func(queries *database.Queries) error) error {
+    tx, err := cfg.DbConn.BeginTx(ctx, nil)
+    if err != nil { return err }
+    defer tx.Rollback()
+
+    if err := fn(cfg.Db.WithTx(tx)); err != nil { return err }
+    return tx.Commit()
+}
+
 func (cfg *ApiConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
     return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
         cfg.FileServerHits.Add(1)
@@ -147,6 +175,44 @@ func (cfg *ApiConfig) HandlePolkaEvent(res http.ResponseWriter, req *http.Reques
     res.WriteHeader(http.StatusNoContent)
 }
 
+// An unsigned number from the environment, or def if it isn't set
+func getEnvUint(name string, def uint64, bitSize int) (uint64, error) {
+    value := os.Getenv(name)
+    if value == "" { return def, nil }
+    parsed, err := strconv.ParseUint(value, 10, bitSize)
+    if err != nil { return 0, fmt.Errorf("%v must be a whole number of at most %v bits", name, bitSize) }
+    return parsed, nil
+}
+
+// New passwords are hashed with argon2id unless PASSWORD_HASHER says otherwise. Hashes from any hasher can
+// still be checked, and are replaced when their owner logs in.
+func getPasswordHasher() (auth.PasswordHasher, error) {
+    switch os.Getenv("PASSWORD_HASHER") {
+    case "", "argon2id":
+        hasher := auth.DefaultArgon2idHasher
+        memory, err := getEnvUint("ARGON2_MEMORY", uint64(hasher.Memory), 32)
+        if err != nil { return nil, err }
+        iterations, err := getEnvUint("ARGON2_ITERATIONS", uint64(hasher.Iterations), 32)
+        if err != nil { return nil, err }
+        parallelism, err := getEnvUint("ARGON2_PARALLELISM", uint64(hasher.Parallelism), 8)
+        if err != nil { return nil, err }
+        if memory == 0 || iterations == 0 || parallelism == 0 { return nil, fmt.Errorf("argon2 parameters must be greater than 0") }
+        hasher.Memory = uint32(memory)
+        hasher.Iterations = uint32(iterations)
+        hasher.Parallelism = uint8(parallelism)
+        return hasher, nil
+    case "bcrypt":
+        cost, err := getEnvUint("BCRYPT_COST", uint64(bcrypt.DefaultCost), 8)
+        if err != nil { return nil, err }
+        if int(cost) < bcrypt.MinCost || int(cost) > bcrypt.MaxCost {
+            return nil, fmt.Errorf("bcrypt cost must be between %v and %v", bcrypt.MinCost, bcrypt.MaxCost)
+        }
+        return auth.BcryptHasher { Cost: int(cost) }, nil
+    default:
+        return nil, fmt.Errorf("password hasher must be either argon2id or bcrypt")
+    }
+}
+
 func main() {
     godotenv.Load()
     dbUrl := os.Getenv("DB_URL")
@@ -173,10 +239,66 @@ func main() {
         fmt.Println("polka key must be set")
         os.Exit(1)
     }
+    var keys *auth.KeySet
+    if keyDir := os.Getenv("JWT_KEY_DIR"); keyDir != "" {
+        keys, err = auth.LoadKeySet(keyDir)
+        if err != nil {
+            fmt.Printf("Failed to load jwt keys: %v\n", err)
+            os.Exit(1)
+        }
+    } else if platform == "dev" {
+        keys, err = auth.GenerateKeySet()
+        if err != nil {
+            fmt.Printf("Failed to generate jwt key: %v\n", err)
+            os.Exit(1)
+        }
+        fmt.Println("JWT_KEY_DIR isn't set, signing access tokens with a temporary key")
+    } else {
+        fmt.Println("jwt key dir must be set")
+        os.Exit(1)
+    }
+    fmt.Printf("Signing access tokens with key %v\n", keys.SigningKeyID())
+    hasher, err := getPasswordHasher()
+    if err != nil {
+        fmt.Printf("Invalid password hashing settings: %v\n", err)
+        os.Exit(1)
+    }
+    passwords, err := auth.NewPasswords(hasher)
+    if err != nil {
+        fmt.Printf("Failed to set up password hashing: %v\n", err)
+        os.Exit(1)
+    }
+    baseUrl := os.Getenv("BASE_URL")
+    if baseUrl == "" { baseUrl = "http://localhost:8080" }
+    mailFrom := os.Getenv("MAIL_FROM")
+    if mailFrom == "" { mailFrom = "chirpy@localhost" }
+    var mailer mail.Mailer
+    switch os.Getenv("MAILER") {
+    case "", "log":
+        mailer = &mail.LogMailer { Dir: os.Getenv("MAIL_DIR"), From: mailFrom }
+    case "smtp":
+        smtpHost := os.Getenv("SMTP_HOST")
+        if smtpHost == "" {
+            fmt.Println("smtp host must be set when using the smtp mailer")
+            os.Exit(1)
+        }
+        smtpPort := os.Getenv("SMTP_PORT")
+        if smtpPort == "" { smtpPort = "587" }
+        mailer = mail.NewSmtpMailer(smtpHost, smtpPort, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), mailFrom)
+    default:
+        fmt.Println("mailer must be either log or smtp")
+        os.Exit(1)
+    }
     apiCfg := ApiConfig {
         Platform: platform,
         PolkaKey: polkaKey,
         Secret: secret,
+        Keys: keys,
+        Passwords: passwords,
+        BaseUrl: strings.TrimSuffix(baseUrl, "/"),
+        RequireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+        Mailer: mailer,
+        DbConn: db,
         Db: dbQueries,
     }
 
@@ -184,6 +306,8 @@ func main() {
     //============================== APP ==============================
     serveMux.Handle("/app/", apiCfg.MiddlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir("site")))))
     //============================== API ==============================
+    // Keys other services verify access tokens with (handlers_jwks.go)
+    serveMux.HandleFunc("GET /.well-known/jwks.json", apiCfg.HandleGetJwks)
     // Health
     serveMux.HandleFunc("GET /api/healthz", func(res http.ResponseWriter, req *http.Request) {
         res.WriteHeader(http.StatusOK)
@@ -191,17 +315,64 @@ func main() {
         res.Write([]byte("OK"))
     })
     // Chirps (handlers_chirps.go)
-    serveMux.HandleFunc("GET /api/chirps", apiCfg.HandleGetChirps)
-    serveMux.HandleFunc("GET /api/chirps/{id}", apiCfg.HandleGetChirp)
-    serveMux.HandleFunc("POST /api/chirps", apiCfg.HandleCreateChirp)
-    serveMux.HandleFunc("DELETE /api/chirps/{id}", apiCfg.HandleDeleteChirp)
+    serveMux.HandleFunc("GET /api/chirps", apiCfg.OptionalAuth(auth.SCOPE_CHIRPS_READ, apiCfg.HandleGetChirps))
+    serveMux.HandleFunc("GET /api/chirps/search", apiCfg.OptionalAuth(auth.SCOPE_CHIRPS_READ, apiCfg.HandleSearchChirps))
+    serveMux.HandleFunc("GET /api/chirps/{id}", apiCfg.OptionalAuth(auth.SCOPE_CHIRPS_READ, apiCfg.HandleGetChirp))
+    serveMux.HandleFunc("GET /api/chirps/{id}/history", apiCfg.HandleGetChirpHistory)
+    serveMux.HandleFunc("GET /api/chirps/{id}/thread", apiCfg.OptionalAuth(auth.SCOPE_CHIRPS_READ, apiCfg.HandleGetChirpThread))
+    serveMux.HandleFunc("POST /api/chirps", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_WRITE, apiCfg.HandleCreateChirp))
+    serveMux.HandleFunc("PATCH /api/chirps/{id}", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_WRITE, apiCfg.HandleEditChirp))
+    serveMux.HandleFunc("DELETE /api/chirps/{id}", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_WRITE, apiCfg.HandleDeleteChirp))
+    serveMux.HandleFunc("GET /api/timeline", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_READ, apiCfg.HandleGetTimeline))
+    // Likes (handlers_likes.go)
+    serveMux.HandleFunc("POST /api/chirps/{id}/like", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_WRITE, apiCfg.HandleLikeChirp))
+    serveMux.HandleFunc("DELETE /api/chirps/{id}/like", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_WRITE, apiCfg.HandleUnlikeChirp))
+    // Rechirps (handlers_rechirps.go)
+    serveMux.HandleFunc("POST /api/chirps/{id}/rechirp", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_WRITE, apiCfg.HandleRechirp))
+    serveMux.HandleFunc("DELETE /api/chirps/{id}/rechirp", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_WRITE, apiCfg.HandleUndoRechirp))
+    // Hashtags (handlers_hashtags.go)
+    serveMux.HandleFunc("GET /api/hashtags/trending", apiCfg.HandleGetTrendingHashtags)
+    serveMux.HandleFunc("GET /api/hashtags/{tag}/chirps", apiCfg.OptionalAuth(auth.SCOPE_CHIRPS_READ, apiCfg.HandleGetHashtagChirps))
     // Users (handlers_users.go)
     serveMux.HandleFunc("POST /api/users", apiCfg.HandleCreateUser)
-    serveMux.HandleFunc("PUT /api/users", apiCfg.HandleUpdateUser)
+    serveMux.HandleFunc("PUT /api/users", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleUpdateUser))
+    serveMux.HandleFunc("PATCH /api/users", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandlePatchUser))
+    serveMux.HandleFunc("GET /api/users/{handle}", apiCfg.HandleGetUserProfile)
+    // Account (handlers_account.go)
+    serveMux.HandleFunc("DELETE /api/users", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleDeleteUser))
+    serveMux.HandleFunc("GET /api/users/export", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleExportUser))
+    // Email verification (handlers_verification.go)
+    serveMux.HandleFunc("GET /api/users/verify", apiCfg.HandleVerifyEmail)
+    serveMux.HandleFunc("POST /api/users/verify/resend", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleResendVerificationEmail))
+    // Follows (handlers_follows.go)
+    serveMux.HandleFunc("POST /api/users/{id}/follow", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_WRITE, apiCfg.HandleFollowUser))
+    serveMux.HandleFunc("DELETE /api/users/{id}/follow", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_WRITE, apiCfg.HandleUnfollowUser))
+    serveMux.HandleFunc("GET /api/users/{id}/followers", apiCfg.HandleGetFollowers)
+    serveMux.HandleFunc("GET /api/users/{id}/following", apiCfg.HandleGetFollowing)
     // Auth (handlers_users.go)
     serveMux.HandleFunc("POST /api/login", apiCfg.HandleLogin)
     serveMux.HandleFunc("POST /api/refresh", apiCfg.HandleRefresh)
     serveMux.HandleFunc("POST /api/revoke", apiCfg.HandleRevoke)
+    // Two-factor auth (handlers_mfa.go)
+    serveMux.HandleFunc("POST /api/login/mfa", apiCfg.HandleLoginMfa)
+    serveMux.HandleFunc("POST /api/users/mfa/totp", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleStartTotpEnrollment))
+    serveMux.HandleFunc("POST /api/users/mfa/totp/confirm", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleConfirmTotpEnrollment))
+    serveMux.HandleFunc("DELETE /api/users/mfa/totp", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleDisableTotp))
+    // Sessions (handlers_sessions.go)
+    serveMux.HandleFunc("GET /api/sessions", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleGetSessions))
+    serveMux.HandleFunc("DELETE /api/sessions/{id}", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleRevokeSession))
+    serveMux.HandleFunc("POST /api/sessions/revoke-all", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleRevokeAllSessions))
+    // API keys (handlers_api_keys.go)
+    serveMux.HandleFunc("POST /api/keys", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleCreateApiKey))
+    serveMux.HandleFunc("GET /api/keys", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleGetApiKeys))
+    serveMux.HandleFunc("DELETE /api/keys/{id}", apiCfg.RequireAuth(auth.SCOPE_ACCOUNT_ADMIN, apiCfg.HandleDeleteApiKey))
+    // Notifications (handlers_notifications.go)
+    serveMux.HandleFunc("GET /api/notifications", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_READ, apiCfg.HandleGetNotifications))
+    serveMux.HandleFunc("POST /api/notifications/read", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_WRITE, apiCfg.HandleMarkNotificationsRead))
+    serveMux.HandleFunc("POST /api/notifications/read-all", apiCfg.RequireAuth(auth.SCOPE_CHIRPS_WRITE, apiCfg.HandleMarkAllNotificationsRead))
+    // Password reset (handlers_password.go)
+    serveMux.HandleFunc("POST /api/password/forgot", apiCfg.HandleForgotPassword)
+    serveMux.HandleFunc("POST /api/password/reset", apiCfg.HandleResetPassword)
     // Webhooks
     serveMux.HandleFunc("POST /api/polka/webhooks", apiCfg.HandlePolkaEvent)
     //============================== ADMIN ==============================
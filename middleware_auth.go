@@ -0,0 +1,114 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "fmt"
+    "errors"
+    "database/sql"
+
+    "github.com/google/uuid"
+
+    "github.com/vedaRadev/chirpy-boot.dev/internal/auth"
+)
+
+// Who a request is made on behalf of and what it's allowed to do, stored in the request context by
+// RequireAuth and OptionalAuth
+type Authentication struct {
+    UserID      uuid.UUID
+    Scopes      []string
+    // Set when the request was made with an API key instead of an access token
+    ApiKeyID    uuid.NullUUID
+}
+
+type authenticationContextKey struct {}
+
+var errInvalidCredentials = errors.New("missing or invalid credentials")
+
+// Read the credentials from the Authorization header. Either an access token (Bearer) or an API key
+// (ApiKey) is accepted.
+func (cfg *ApiConfig) authenticate(req *http.Request) (Authentication, error, int) {
+    if apiKey, err := auth.GetApiKey(req.Header); err == nil {
+        key, err := cfg.Db.GetApiKeyByHash(req.Context(), auth.HashToken(apiKey))
+        if errors.Is(err, sql.ErrNoRows) { return Authentication {}, errInvalidCredentials, http.StatusUnauthorized }
+        if err != nil {
+            fmt.Printf("Failed to look up api key: %v\n", err)
+            return Authentication {}, errors.New("failed to validate api key"), http.StatusInternalServerError
+        }
+        if err := cfg.Db.TouchApiKey(req.Context(), key.ID); err != nil {
+            fmt.Printf("Failed to update last use of api key %v: %v\n", key.ID, err)
+        }
+        return Authentication {
+            UserID: key.UserID,
+            Scopes: key.Scopes,
+            ApiKeyID: uuid.NullUUID { UUID: key.ID, Valid: true },
+        }, nil, 0
+    }
+
+    accessToken, err := auth.GetBearerToken(req.Header)
+    if err != nil { return Authentication {}, errInvalidCredentials, http.StatusUnauthorized }
+    userId, scopes, err := auth.ValidateJWT(accessToken, cfg.Keys, auth.AccessTokenValidation)
+    if err != nil { return Authentication {}, errInvalidCredentials, http.StatusUnauthorized }
+    return Authentication { UserID: userId, Scopes: scopes }, nil, 0
+}
+
+func sendAuthErrorResponse(res http.ResponseWriter, code int, message string, scope string) {
+    switch code {
+    case http.StatusUnauthorized:
+        res.Header().Set("WWW-Authenticate", `Bearer realm="chirpy"`)
+    case http.StatusForbidden:
+        res.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="chirpy", error="insufficient_scope", scope="%v"`, scope))
+    }
+    SendJsonErrorResponse(res, code, message)
+}
+
+// Only let requests through that have credentials granting scope. Handlers behind it get the user with
+// GetAuthenticatedUserId.
+func (cfg *ApiConfig) RequireAuth(scope string, next http.HandlerFunc) http.HandlerFunc {
+    return func(res http.ResponseWriter, req *http.Request) {
+        authentication, err, errCode := cfg.authenticate(req)
+        if err != nil {
+            sendAuthErrorResponse(res, errCode, err.Error(), scope)
+            return
+        }
+        if !auth.HasScope(authentication.Scopes, scope) {
+            sendAuthErrorResponse(res, http.StatusForbidden, fmt.Sprintf("credentials are missing the %v scope", scope), scope)
+            return
+        }
+
+        next(res, req.WithContext(context.WithValue(req.Context(), authenticationContextKey {}, authentication)))
+    }
+}
+
+// Like RequireAuth but for endpoints that also serve anonymous requests. Requests without an Authorization
+// header are let through unauthenticated, but credentials that are sent still have to be valid.
+func (cfg *ApiConfig) OptionalAuth(scope string, next http.HandlerFunc) http.HandlerFunc {
+    required := cfg.RequireAuth(scope, next)
+    return func(res http.ResponseWriter, req *http.Request) {
+        if req.Header.Get("Authorization") == "" {
+            next(res, req)
+            return
+        }
+        required(res, req)
+    }
+}
+
+// The credentials of the request, if it went through RequireAuth or OptionalAuth and had any
+func GetAuthentication(req *http.Request) (Authentication, bool) {
+    authentication, ok := req.Context().Value(authenticationContextKey {}).(Authentication)
+    return authentication, ok
+}
+
+// The user a request is made on behalf of. Only for handlers behind RequireAuth.
+func GetAuthenticatedUserId(req *http.Request) uuid.UUID {
+    authentication, ok := GetAuthentication(req)
+    if !ok { panic(fmt.Sprintf("%v %v isn't behind RequireAuth", req.Method, req.URL.Path)) }
+    return authentication.UserID
+}
+
+// The user a request is made on behalf of, if any. For handlers behind OptionalAuth.
+func GetOptionalAuthenticatedUserId(req *http.Request) uuid.NullUUID {
+    authentication, ok := GetAuthentication(req)
+    if !ok { return uuid.NullUUID {} }
+    return uuid.NullUUID { UUID: authentication.UserID, Valid: true }
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+    "net/http"
+    "net/url"
+    "fmt"
+    "errors"
+    "strconv"
+    "strings"
+    "time"
+    "encoding/base64"
+
+    "github.com/google/uuid"
+)
+
+const DEFAULT_PAGE_LIMIT int = 20
+const MAX_PAGE_LIMIT int = 100
+
+// Position in a keyset-paginated listing ordered by (created_at, id). Clients only ever see the encoded
+// form and should treat it as opaque.
+type PageCursor struct {
+    CreatedAt time.Time
+    ID uuid.UUID
+    // Set when the cursor points at the page before the one it was taken from
+    Backward bool
+}
+
+func (cursor PageCursor) Encode() string {
+    direction := "n"
+    if cursor.Backward { direction = "p" }
+    raw := fmt.Sprintf("%s:%d:%s", direction, cursor.CreatedAt.UnixMicro(), cursor.ID)
+    return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodePageCursor(encoded string) (PageCursor, error) {
+    var cursor PageCursor
+    invalid := errors.New("invalid cursor")
+
+    raw, err := base64.RawURLEncoding.DecodeString(encoded)
+    if err != nil { return cursor, invalid }
+    fields := strings.Split(string(raw), ":")
+    if len(fields) != 3 { return cursor, invalid }
+
+    switch fields[0] {
+        case "n": cursor.Backward = false
+        case "p": cursor.Backward = true
+        default: return cursor, invalid
+    }
+    micros, err := strconv.ParseInt(fields[1], 10, 64)
+    if err != nil { return cursor, invalid }
+    cursor.CreatedAt = dbTime(time.UnixMicro(micros))
+    cursor.ID, err = uuid.Parse(fields[2])
+    if err != nil { return cursor, invalid }
+
+    return cursor, nil
+}
+
+// Read the "limit" query parameter, falling back to the default page size
+func GetPageLimit(queryValues url.Values) (int, error) {
+    limitStr := queryValues.Get("limit")
+    if limitStr == "" { return DEFAULT_PAGE_LIMIT, nil }
+    limit, err := strconv.Atoi(limitStr)
+    if err != nil || limit < 1 || limit > MAX_PAGE_LIMIT {
+        return 0, fmt.Errorf("limit must be between 1 and %d", MAX_PAGE_LIMIT)
+    }
+    return limit, nil
+}
+
+// Read the "limit" and "cursor" query parameters. The returned cursor is nil when the client is asking
+// for the first page.
+func GetPageParameters(queryValues url.Values) (int, *PageCursor, error) {
+    limit, err := GetPageLimit(queryValues)
+    if err != nil { return 0, nil, err }
+
+    cursorStr := queryValues.Get("cursor")
+    if cursorStr == "" { return limit, nil, nil }
+    cursor, err := DecodePageCursor(cursorStr)
+    if err != nil { return 0, nil, err }
+
+    return limit, &cursor, nil
+}
+
+// Trim a page that was fetched with limit+1 rows in the direction of the cursor and work out the
+// cursors for the neighbouring pages. Pages walked backward are expected to have been fetched in
+// reverse order and are flipped back around here.
+func PaginateResults[T any](
+    items []T,
+    limit int,
+    cursor *PageCursor,
+    getKey func(T) (time.Time, uuid.UUID),
+) ([]T, *string, *string) {
+    if items == nil { items = []T {} }
+    hasMore := len(items) > limit
+    if hasMore { items = items[:limit] }
+
+    backward := cursor != nil && cursor.Backward
+    if backward {
+        for i, j := 0, len(items) - 1; i < j; i, j = i + 1, j - 1 {
+            items[i], items[j] = items[j], items[i]
+        }
+    }
+    if len(items) == 0 { return items, nil, nil }
+
+    var nextCursor, prevCursor *string
+    // Going forward there's only a previous page if we didn't start at the beginning; going backward
+    // there's always a next page since that's where we came from.
+    if hasMore || backward {
+        createdAt, id := getKey(items[len(items) - 1])
+        encoded := PageCursor { CreatedAt: createdAt, ID: id }.Encode()
+        nextCursor = &encoded
+    }
+    if (backward && hasMore) || (!backward && cursor != nil) {
+        createdAt, id := getKey(items[0])
+        encoded := PageCursor { CreatedAt: createdAt, ID: id, Backward: true }.Encode()
+        prevCursor = &encoded
+    }
+
+    return items, nextCursor, prevCursor
+}
+
+// Advertise the neighbouring pages via a Link header (RFC 8288)
+func SetPaginationLinks(res http.ResponseWriter, req *http.Request, nextCursor, prevCursor *string) {
+    var links []string
+    addLink := func(cursor *string, rel string) {
+        if cursor == nil { return }
+        queryValues := req.URL.Query()
+        queryValues.Set("cursor", *cursor)
+        link := url.URL { Path: req.URL.Path, RawQuery: queryValues.Encode() }
+        links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, link.String(), rel))
+    }
+    addLink(nextCursor, "next")
+    addLink(prevCursor, "prev")
+
+    if len(links) > 0 { res.Header().Set("Link", strings.Join(links, ", ")) }
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+    "testing"
+    "slices"
+    "time"
+    "encoding/base64"
+
+    "github.com/google/uuid"
+)
+
+func TestPageCursorRoundTrip(t *testing.T) {
+    testCases := []PageCursor {
+        { CreatedAt: time.Date(2024, 6, 1, 12, 30, 0, 123456000, time.UTC), ID: uuid.New() },
+        { CreatedAt: time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC), ID: uuid.New(), Backward: true },
+        { CreatedAt: time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC), ID: uuid.New() },
+        { CreatedAt: time.Unix(0, 0).UTC(), ID: uuid.Nil, Backward: true },
+    }
+
+    for i, cursor := range testCases {
+        decoded, err := DecodePageCursor(cursor.Encode())
+        if err != nil {
+            t.Errorf("Test case %v: decoding failed but shouldn't have: %v\n", i, err)
+            continue
+        }
+        if !decoded.CreatedAt.Equal(cursor.CreatedAt) || decoded.CreatedAt.Location() != time.UTC {
+            t.Errorf("Test case %v: expected created at %v in UTC, got %v\n", i, cursor.CreatedAt, decoded.CreatedAt)
+        }
+        if decoded.ID != cursor.ID {
+            t.Errorf("Test case %v: expected id %v, got %v\n", i, cursor.ID, decoded.ID)
+        }
+        if decoded.Backward != cursor.Backward {
+            t.Errorf("Test case %v: expected backward to be %v, got %v\n", i, cursor.Backward, decoded.Backward)
+        }
+    }
+
+    // Postgres only keeps microseconds, so that's all the cursor keeps too
+    precise := PageCursor { CreatedAt: time.Date(2024, 6, 1, 0, 0, 0, 123456789, time.UTC), ID: uuid.New() }
+    decoded, err := DecodePageCursor(precise.Encode())
+    if err != nil || !decoded.CreatedAt.Equal(precise.CreatedAt.Truncate(time.Microsecond)) {
+        t.Errorf("Expected the cursor to keep microseconds, got %v (%v)\n", decoded.CreatedAt, err)
+    }
+}
+
+func TestDecodeInvalidPageCursor(t *testing.T) {
+    encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+    id := uuid.New().String()
+    valid := PageCursor { CreatedAt: time.Now(), ID: uuid.New() }.Encode()
+
+    testCases := []string {
+        "",
+        "not base64!",
+        // Padded encodings aren't what Encode produces
+        base64.URLEncoding.EncodeToString([]byte("n:1:" + id)),
+        encode("n:1"),
+        encode("n:1:" + id + ":extra"),
+        encode("x:1:" + id),
+        encode("N:1:" + id),
+        encode(":1:" + id),
+        encode("n::" + id),
+        encode("n:abc:" + id),
+        encode("n:1.5:" + id),
+        encode("n:99999999999999999999:" + id),
+        encode("n:1:not-a-uuid"),
+        encode("n:1:"),
+        // Tampered with after encoding
+        valid[:len(valid) - 2],
+        valid + "A",
+        "A" + valid,
+    }
+
+    for i, encoded := range testCases {
+        if cursor, err := DecodePageCursor(encoded); err == nil {
+            t.Errorf("Test case %v: decoding %q succeeded but shouldn't have, got %+v\n", i, encoded, cursor)
+        }
+    }
+}
+
+func TestPaginateResults(t *testing.T) {
+    const limit = 3
+    base := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+    ids := make([]uuid.UUID, 10)
+    for i := range ids { ids[i] = uuid.New() }
+    getKey := func(i int) (time.Time, uuid.UUID) { return base.Add(time.Duration(i) * time.Minute), ids[i] }
+    forward := &PageCursor { CreatedAt: base, ID: ids[0] }
+    backward := &PageCursor { CreatedAt: base, ID: ids[0], Backward: true }
+
+    type TestCase struct {
+        items []int
+        cursor *PageCursor
+        expectedItems []int
+        // Index of the item the cursor should point at, -1 for no cursor
+        expectedNext int
+        expectedPrev int
+    }
+    testCases := []TestCase {
+        { items: nil, cursor: nil, expectedItems: []int {}, expectedNext: -1, expectedPrev: -1 },
+        { items: []int {}, cursor: forward, expectedItems: []int {}, expectedNext: -1, expectedPrev: -1 },
+        { items: []int {}, cursor: backward, expectedItems: []int {}, expectedNext: -1, expectedPrev: -1 },
+        // First page
+        { items: []int { 1, 2 }, cursor: nil, expectedItems: []int { 1, 2 }, expectedNext: -1, expectedPrev: -1 },
+        { items: []int { 1, 2, 3 }, cursor: nil, expectedItems: []int { 1, 2, 3 }, expectedNext: -1, expectedPrev: -1 },
+        { items: []int { 1, 2, 3, 4 }, cursor: nil, expectedItems: []int { 1, 2, 3 }, expectedNext: 3, expectedPrev: -1 },
+        // Later pages going forward always have a previous page
+        { items: []int { 4, 5, 6, 7 }, cursor: forward, expectedItems: []int { 4, 5, 6 }, expectedNext: 6, expectedPrev: 4 },
+        { items: []int { 4, 5, 6 }, cursor: forward, expectedItems: []int { 4, 5, 6 }, expectedNext: -1, expectedPrev: 4 },
+        { items: []int { 4 }, cursor: forward, expectedItems: []int { 4 }, expectedNext: -1, expectedPrev: 4 },
+        // Going backward the rows come in reverse and there's always a next page
+        { items: []int { 6, 5, 4, 3 }, cursor: backward, expectedItems: []int { 4, 5, 6 }, expectedNext: 6, expectedPrev: 4 },
+        { items: []int { 3, 2, 1 }, cursor: backward, expectedItems: []int { 1, 2, 3 }, expectedNext: 3, expectedPrev: -1 },
+        { items: []int { 1 }, cursor: backward, expectedItems: []int { 1 }, expectedNext: 1, expectedPrev: -1 },
+    }
+
+    checkCursor := func(i int, name string, actual *string, expectedIndex int, expectedBackward bool) {
+        if expectedIndex < 0 {
+            if actual != nil { t.Errorf("Test case %v: expected no %v cursor, got %v\n", i, name, *actual) }
+            return
+        }
+        if actual == nil {
+            t.Errorf("Test case %v: expected a %v cursor but got none\n", i, name)
+            return
+        }
+        cursor, err := DecodePageCursor(*actual)
+        if err != nil {
+            t.Errorf("Test case %v: %v cursor doesn't decode: %v\n", i, name, err)
+            return
+        }
+        createdAt, id := getKey(expectedIndex)
+        if !cursor.CreatedAt.Equal(createdAt) || cursor.ID != id || cursor.Backward != expectedBackward {
+            t.Errorf("Test case %v: %v cursor doesn't point at item %v\n", i, name, expectedIndex)
+        }
+    }
+
+    for i, testCase := range testCases {
+        items, next, prev := PaginateResults(testCase.items, limit, testCase.cursor, getKey)
+        if items == nil || !slices.Equal(items, testCase.expectedItems) {
+            t.Errorf("Test case %v: expected items %v, got %v\n", i, testCase.expectedItems, items)
+        }
+        checkCursor(i, "next", next, testCase.expectedNext, false)
+        checkCursor(i, "prev", prev, testCase.expectedPrev, true)
+    }
+}
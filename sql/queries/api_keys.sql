@@ -0,0 +1,17 @@
+-- name: CreateApiKey :one
+INSERT INTO api_keys (id, created_at, user_id, name, key_hash, key_prefix, scopes, last_used_at)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, $5, NULL)
+RETURNING *;
+
+-- API keys are looked up by the sha256 hash of the key the client sent
+-- name: GetApiKeyByHash :one
+SELECT * FROM api_keys WHERE key_hash = $1;
+
+-- name: GetUserApiKeys :many
+SELECT * FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC;
+
+-- name: DeleteUserApiKey :execrows
+DELETE FROM api_keys WHERE id = $1 AND user_id = $2;
+
+-- name: TouchApiKey :exec
+UPDATE api_keys SET last_used_at = NOW() WHERE id = $1;
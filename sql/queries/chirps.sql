@@ -1,25 +1,78 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, user_id, body)
-VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2)
+INSERT INTO chirps (id, created_at, updated_at, user_id, body, parent_id, rechirp_of, quote_of)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4, $5)
 RETURNING *;
 
--- TODO figure out a way to collate GetChirps<sortorder> and GetUserChirps<sortorder>
--- might need to shift away from sqlc to something that allows for dynamic query generation
+-- Chirp listings are built dynamically by ListChirps in internal/database/chirp_query.go
 
--- name: GetChirps :many
-SELECT * FROM chirps ORDER BY created_at ASC;
+-- name: GetChirp :one
+SELECT * FROM chirps WHERE id = $1;
 
--- name: GetChirpsDesc :many
-SELECT * FROM chirps ORDER BY created_at DESC;
+-- name: GetChirpsByIds :many
+SELECT * FROM chirps WHERE id = ANY(sqlc.arg('ids')::uuid[]);
 
--- name: GetUserChirps :many
-SELECT * FROM chirps WHERE user_id = $1 ORDER BY created_at ASC;
+-- The old body is saved as a revision in the same statement. Both parts see the same snapshot of the
+-- table, so the revision always gets the body from before the update.
+-- name: UpdateChirpBody :one
+WITH revision AS (
+    INSERT INTO chirp_revisions (id, created_at, chirp_id, body)
+    SELECT gen_random_uuid(), NOW(), id, body FROM chirps WHERE id = $1
+)
+UPDATE chirps
+SET body = $2, edited_at = NOW(), updated_at = NOW()
+WHERE id = $1
+RETURNING *;
 
--- name: GetUserChirpsDesc :many
-SELECT * FROM chirps WHERE user_id = $1 ORDER BY created_at DESC;
+-- name: GetChirpRevisions :many
+SELECT * FROM chirp_revisions WHERE chirp_id = $1 ORDER BY created_at ASC;
 
--- name: GetChirp :one
-SELECT * FROM chirps WHERE id = $1;
+-- Walks up the reply chain from a chirp, nearest parent first
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of, 1 AS depth
+    FROM chirps
+    WHERE chirps.id = (SELECT c.parent_id FROM chirps c WHERE c.id = sqlc.arg('id'))
+    UNION ALL
+    SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.edited_at, c.parent_id, c.rechirp_of, c.quote_of, a.depth + 1
+    FROM chirps c INNER JOIN ancestors a ON c.id = a.parent_id
+    WHERE a.depth < sqlc.arg('max_depth')::int
+)
+SELECT * FROM ancestors ORDER BY depth ASC;
+
+-- Breadth-first walk down the replies to a chirp. Rows are ordered so a reply always comes after the
+-- chirp it replied to.
+-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of, 1 AS depth
+    FROM chirps
+    WHERE chirps.parent_id = sqlc.arg('id')::uuid
+    UNION ALL
+    SELECT c.id, c.created_at, c.updated_at, c.body, c.user_id, c.edited_at, c.parent_id, c.rechirp_of, c.quote_of, d.depth + 1
+    FROM chirps c INNER JOIN descendants d ON c.parent_id = d.id
+    WHERE d.depth < sqlc.arg('max_depth')::int
+)
+SELECT * FROM descendants
+ORDER BY depth ASC, created_at ASC, id ASC
+LIMIT sqlc.arg('max_replies');
 
 -- name: DeleteChirp :one
 DELETE FROM chirps WHERE id = $1 RETURNING NULL;
+
+-- name: DeleteRechirp :execrows
+DELETE FROM chirps WHERE user_id = $1 AND rechirp_of = $2;
+
+-- Matches are wrapped in \x02 and \x03 in the snippet, which are taken out of the body first so they can't be faked
+-- name: SearchChirps :many
+SELECT
+    id, created_at, updated_at, body, user_id, edited_at, parent_id, rechirp_of, quote_of,
+    ts_rank(to_tsvector('english', body), websearch_to_tsquery('english', sqlc.arg('query')))::real AS rank,
+    ts_headline(
+        'english',
+        translate(body, E'\x02\x03', ''),
+        websearch_to_tsquery('english', sqlc.arg('query')),
+        E'StartSel=\x02, StopSel=\x03'
+    )::text AS snippet
+FROM chirps
+WHERE to_tsvector('english', body) @@ websearch_to_tsquery('english', sqlc.arg('query'))
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit') OFFSET sqlc.arg('page_offset');
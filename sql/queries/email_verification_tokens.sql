@@ -0,0 +1,11 @@
+-- name: CreateEmailVerificationToken :one
+INSERT INTO email_verification_tokens (id, created_at, user_id, email, expires_at, used_at)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, NULL)
+RETURNING *;
+
+-- Marks the token used in the same statement that reads it, so it can only ever be used once
+-- name: UseEmailVerificationToken :one
+UPDATE email_verification_tokens
+SET used_at = NOW()
+WHERE id = $1 AND used_at IS NULL
+RETURNING *;
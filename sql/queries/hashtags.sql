@@ -0,0 +1,24 @@
+-- The no-op update makes RETURNING give back hashtags that already existed too
+-- name: UpsertHashtag :one
+INSERT INTO hashtags (id, created_at, tag)
+VALUES (gen_random_uuid(), NOW(), $1)
+ON CONFLICT (tag) DO UPDATE SET tag = EXCLUDED.tag
+RETURNING *;
+
+-- name: AddChirpHashtag :exec
+INSERT INTO chirp_hashtags (chirp_id, hashtag_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: ClearChirpHashtags :exec
+DELETE FROM chirp_hashtags WHERE chirp_id = $1;
+
+-- name: GetTrendingHashtags :many
+SELECT h.tag, COUNT(*) AS chirp_count
+FROM chirp_hashtags ch
+INNER JOIN hashtags h ON ch.hashtag_id = h.id
+INNER JOIN chirps c ON ch.chirp_id = c.id
+WHERE c.created_at >= sqlc.arg('since')
+GROUP BY h.tag
+ORDER BY chirp_count DESC, h.tag ASC
+LIMIT sqlc.arg('page_limit');
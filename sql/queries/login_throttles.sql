@@ -0,0 +1,22 @@
+-- Makes sure every key has a row that LockLoginThrottles can lock
+-- name: CreateLoginThrottles :exec
+INSERT INTO login_throttles (key, failures, last_failure_at, locked_until)
+SELECT unnest(sqlc.arg('keys')::text[]), 0, sqlc.arg('created_at')::timestamp, sqlc.arg('created_at')::timestamp
+ON CONFLICT (key) DO NOTHING;
+
+-- Locked until the end of the transaction, so concurrent login attempts are counted one after another.
+-- Always locked in the same order so they can't deadlock.
+-- name: LockLoginThrottles :many
+SELECT * FROM login_throttles WHERE key = ANY(sqlc.arg('keys')::text[]) ORDER BY key FOR UPDATE;
+
+-- name: UpdateLoginThrottle :exec
+UPDATE login_throttles
+SET failures = $2, last_failure_at = $3, locked_until = $4
+WHERE key = $1;
+
+-- Takes back an attempt that turned out not to be a failure
+-- name: ForgiveLoginAttempt :exec
+UPDATE login_throttles SET failures = GREATEST(failures - 1, 0) WHERE key = $1;
+
+-- name: ClearLoginFailures :exec
+DELETE FROM login_throttles WHERE key = $1;
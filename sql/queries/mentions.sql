@@ -0,0 +1,9 @@
+-- name: AddMention :execrows
+INSERT INTO mentions (chirp_id, user_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT DO NOTHING;
+
+-- Drop mentions of users that an edit removed from the chirp
+-- name: RemoveStaleMentions :exec
+DELETE FROM mentions
+WHERE chirp_id = sqlc.arg('chirp_id') AND NOT (user_id = ANY(sqlc.arg('user_ids')::uuid[]));
@@ -0,0 +1,12 @@
+-- name: CreateMfaRecoveryCode :exec
+INSERT INTO mfa_recovery_codes (code_hash, created_at, user_id, used_at)
+VALUES ($1, NOW(), $2, NULL);
+
+-- Marks the code used in the same statement that checks it, so it can only ever be used once
+-- name: UseMfaRecoveryCode :execrows
+UPDATE mfa_recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: DeleteMfaRecoveryCodes :exec
+DELETE FROM mfa_recovery_codes WHERE user_id = $1;
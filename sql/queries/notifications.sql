@@ -0,0 +1,30 @@
+-- A like that was undone keeps its notification, so liking the chirp again doesn't make another one
+-- name: CreateNotification :exec
+INSERT INTO notifications (id, created_at, user_id, actor_id, kind, chirp_id, read_at)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, NULL)
+ON CONFLICT DO NOTHING;
+
+-- Newest first, keyset paginated on (created_at, id)
+-- name: GetNotifications :many
+SELECT * FROM notifications
+WHERE user_id = sqlc.arg('user_id')
+    AND (NOT sqlc.arg('unread_only')::boolean OR read_at IS NULL)
+    AND (
+        sqlc.narg('before_created_at')::timestamp IS NULL
+        OR (created_at, id) < (sqlc.narg('before_created_at')::timestamp, sqlc.narg('before_id')::uuid)
+    )
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: CountUnreadNotifications :one
+SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: MarkNotificationsRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = sqlc.arg('user_id') AND id = ANY(sqlc.arg('ids')::uuid[]) AND read_at IS NULL;
+
+-- name: MarkAllNotificationsRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = $1 AND read_at IS NULL;
@@ -0,0 +1,20 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (token_hash, created_at, user_id, expires_at, used_at)
+VALUES ($1, NOW(), $2, $3, NULL);
+
+-- name: GetPasswordResetToken :one
+SELECT * FROM password_reset_tokens
+WHERE token_hash = $1 AND used_at IS NULL;
+
+-- Marks the token used in the same statement that reads it, so it can only ever be used once
+-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL
+RETURNING *;
+
+-- Once the password has been reset any other outstanding reset emails shouldn't work anymore
+-- name: InvalidatePasswordResetTokens :exec
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
@@ -1,18 +1,53 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
-VALUES ($1, NOW(), NOW(), $2, $3, NULL)
+INSERT INTO refresh_tokens (
+    id, token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, session_created_at, user_agent, ip
+)
+VALUES (gen_random_uuid(), $1, NOW(), NOW(), $2, $3, NULL, $4, $5, $6, $7)
 RETURNING *;
 
+-- Refresh tokens are looked up by the sha256 hash of the token the client sent
 -- name: GetRefreshToken :one
-SELECT * FROM refresh_tokens WHERE token = $1;
+SELECT * FROM refresh_tokens WHERE token_hash = $1;
+
+-- name: GetUserRefreshTokens :many
+SELECT * FROM refresh_tokens WHERE user_id = $1 ORDER BY created_at ASC;
+
+-- Only the newest token of a family is ever active, so this is one row per session
+-- name: GetUserSessions :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+ORDER BY created_at DESC;
 
 -- name: GetUserFromRefreshToken :one
 SELECT u.*
 FROM refresh_tokens r INNER JOIN users u ON r.user_id = u.id
-WHERE r.token = $1;
+WHERE r.token_hash = $1;
 
 -- name: RevokeRefreshToken :one
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
-WHERE token = $1
+WHERE token_hash = $1
 RETURNING *;
+
+-- Revokes a token that's being exchanged for a new one. Only revokes the token if it wasn't already, so
+-- when the same token is used twice at the same time just one of the uses gets a row back.
+-- name: RevokeActiveRefreshToken :one
+UPDATE refresh_tokens
+SET revoked_at = NOW(), rotated_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND revoked_at IS NULL
+RETURNING *;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
@@ -0,0 +1,8 @@
+-- Affects no rows if the challenge was already used
+-- name: UseMfaChallenge :execrows
+INSERT INTO used_mfa_challenges (id, user_id, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: DeleteExpiredMfaChallenges :exec
+DELETE FROM used_mfa_challenges WHERE expires_at < $1;
@@ -1,18 +1,53 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
-VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, $3)
 RETURNING *;
 
 -- name: Reset :one
 DELETE FROM users RETURNING NULL;
 
+-- Everything else belonging to the user goes with them through ON DELETE CASCADE
+-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1;
+
+-- name: GetUser :one
+SELECT * FROM users WHERE id = $1;
+
 -- name: GetUserByEmail :one
 SELECT * FROM users WHERE email = $1;
 
+-- name: GetUserByHandle :one
+SELECT * FROM users WHERE handle = $1;
+
+-- name: GetUsersByHandles :many
+SELECT * FROM users WHERE handle = ANY(sqlc.arg('handles')::text[]);
+
+-- name: GetUsersByIds :many
+SELECT * FROM users WHERE id = ANY(sqlc.arg('ids')::uuid[]);
+
+-- Only the fields that aren't NULL are changed. Changing the email means it has to be verified again.
 -- name: UpdateUser :one
 UPDATE users
-SET email = $2, hashed_password = $3
-WHERE id = $1
+SET
+    email = COALESCE(sqlc.narg('email'), email),
+    email_verified_at = CASE
+        WHEN sqlc.narg('email') IS NULL OR sqlc.narg('email') = email THEN email_verified_at
+        ELSE NULL
+    END,
+    hashed_password = COALESCE(sqlc.narg('hashed_password'), hashed_password),
+    handle = COALESCE(sqlc.narg('handle'), handle),
+    display_name = COALESCE(sqlc.narg('display_name'), display_name),
+    bio = COALESCE(sqlc.narg('bio'), bio),
+    website = COALESCE(sqlc.narg('website'), website),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- Does nothing if the email was changed after the verification was sent
+-- name: VerifyUserEmail :one
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2
 RETURNING *;
 
 -- name: UpgradeUserToChirpyRed :one
@@ -20,3 +55,34 @@ UPDATE users
 SET is_chirpy_red = true
 WHERE id = $1
 RETURNING *;
+
+-- Replaces the secret of an enrollment that wasn't confirmed. Does nothing once two-factor auth is on.
+-- name: StartTotpEnrollment :execrows
+UPDATE users
+SET totp_secret = $2, totp_last_used_step = NULL, updated_at = NOW()
+WHERE id = $1 AND totp_enabled_at IS NULL;
+
+-- The step of the code used to confirm is recorded so that code can't also be used to log in
+-- name: EnableTotp :execrows
+UPDATE users
+SET totp_enabled_at = NOW(), totp_last_used_step = $2, updated_at = NOW()
+WHERE id = $1 AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL;
+
+-- Only succeeds for a later step than the last one used, so a code works once even if it's sent twice
+-- at the same time
+-- name: UseTotpStep :execrows
+UPDATE users
+SET totp_last_used_step = $2
+WHERE id = $1 AND (totp_last_used_step IS NULL OR totp_last_used_step < $2);
+
+-- name: DisableTotp :exec
+UPDATE users
+SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_used_step = NULL, updated_at = NOW()
+WHERE id = $1;
+
+-- Only replaces the hash the password was checked against, so it can't undo a password change that
+-- happened in the meantime
+-- name: RehashUserPassword :exec
+UPDATE users
+SET hashed_password = sqlc.arg('new_hash')
+WHERE id = sqlc.arg('id') AND hashed_password = sqlc.arg('old_hash');
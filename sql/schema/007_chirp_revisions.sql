@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN edited_at TIMESTAMP;
+
+CREATE TABLE chirp_revisions (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    chirp_id UUID NOT NULL,
+    body TEXT NOT NULL,
+    FOREIGN KEY (chirp_id) REFERENCES chirps (id) ON DELETE CASCADE
+);
+CREATE INDEX chirp_revisions_chirp_id_idx ON chirp_revisions (chirp_id, created_at);
+
+-- +goose Down
+DROP TABLE chirp_revisions;
+ALTER TABLE chirps DROP COLUMN edited_at;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Replies outlive the chirp they replied to, they just become the root of their own thread
+ALTER TABLE chirps ADD COLUMN parent_id UUID REFERENCES chirps (id) ON DELETE SET NULL;
+CREATE INDEX chirps_parent_id_idx ON chirps (parent_id);
+
+-- +goose Down
+DROP INDEX chirps_parent_id_idx;
+ALTER TABLE chirps DROP COLUMN parent_id;
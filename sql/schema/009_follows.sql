@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE follows (
+    follower_id UUID NOT NULL,
+    followee_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (follower_id, followee_id),
+    FOREIGN KEY (follower_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (followee_id) REFERENCES users (id) ON DELETE CASCADE,
+    CHECK (follower_id <> followee_id)
+);
+CREATE INDEX follows_followee_id_idx ON follows (followee_id, created_at);
+
+-- +goose Down
+DROP TABLE follows;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- Plain rechirps have an empty body and disappear along with the chirp they re-shared. Quotes carry
+-- their own commentary so they're kept, they just stop pointing at the deleted chirp.
+ALTER TABLE chirps ADD COLUMN rechirp_of UUID REFERENCES chirps (id) ON DELETE CASCADE;
+ALTER TABLE chirps ADD COLUMN quote_of UUID REFERENCES chirps (id) ON DELETE SET NULL;
+CREATE UNIQUE INDEX chirps_user_id_rechirp_of_idx ON chirps (user_id, rechirp_of) WHERE rechirp_of IS NOT NULL;
+CREATE INDEX chirps_quote_of_idx ON chirps (quote_of);
+
+-- +goose Down
+DROP INDEX chirps_quote_of_idx;
+DROP INDEX chirps_user_id_rechirp_of_idx;
+ALTER TABLE chirps DROP COLUMN quote_of;
+ALTER TABLE chirps DROP COLUMN rechirp_of;
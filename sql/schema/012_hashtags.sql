@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE hashtags (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    tag TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE chirp_hashtags (
+    chirp_id UUID NOT NULL,
+    hashtag_id UUID NOT NULL,
+    PRIMARY KEY (chirp_id, hashtag_id),
+    FOREIGN KEY (chirp_id) REFERENCES chirps (id) ON DELETE CASCADE,
+    FOREIGN KEY (hashtag_id) REFERENCES hashtags (id) ON DELETE CASCADE
+);
+CREATE INDEX chirp_hashtags_hashtag_id_idx ON chirp_hashtags (hashtag_id);
+
+-- +goose Down
+DROP TABLE chirp_hashtags;
+DROP TABLE hashtags;
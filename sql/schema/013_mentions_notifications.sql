@@ -0,0 +1,34 @@
+-- +goose Up
+-- Existing users don't have a handle until they pick one
+ALTER TABLE users ADD COLUMN handle TEXT UNIQUE;
+
+CREATE TABLE mentions (
+    chirp_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (chirp_id, user_id),
+    FOREIGN KEY (chirp_id) REFERENCES chirps (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX mentions_user_id_idx ON mentions (user_id);
+
+CREATE TABLE notifications (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    actor_id UUID NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('mention', 'reply', 'like')),
+    chirp_id UUID NOT NULL,
+    read_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (chirp_id) REFERENCES chirps (id) ON DELETE CASCADE
+);
+CREATE INDEX notifications_user_id_created_at_idx ON notifications (user_id, created_at);
+-- Liking, unliking and liking again shouldn't notify the author over and over
+CREATE UNIQUE INDEX notifications_like_idx ON notifications (user_id, actor_id, chirp_id) WHERE kind = 'like';
+
+-- +goose Down
+DROP TABLE notifications;
+DROP TABLE mentions;
+ALTER TABLE users DROP COLUMN handle;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- The handle was added along with mentions, these are the rest of the public profile
+ALTER TABLE users
+    ADD COLUMN display_name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN bio TEXT NOT NULL DEFAULT '',
+    ADD COLUMN website TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE users
+    DROP COLUMN display_name,
+    DROP COLUMN bio,
+    DROP COLUMN website;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- NULL until the user proves they own their email, and reset whenever it changes
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE users DROP COLUMN email_verified_at;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Tokens are tied to the email they were sent to, so changing the email invalidates any sent before
+CREATE TABLE email_verification_tokens (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    email TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE email_verification_tokens;
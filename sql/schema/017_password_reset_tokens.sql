@@ -0,0 +1,13 @@
+-- +goose Up
+-- Only a hash of each token is stored, the token itself only ever exists in the email that was sent
+CREATE TABLE password_reset_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE password_reset_tokens;
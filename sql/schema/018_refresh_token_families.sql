@@ -0,0 +1,11 @@
+-- +goose Up
+-- Every refresh token handed out by rotating another one belongs to the same family as the token it
+-- replaced, starting from the one created at login
+ALTER TABLE refresh_tokens ADD COLUMN family_id UUID;
+UPDATE refresh_tokens SET family_id = gen_random_uuid();
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_family_id_idx;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- Refresh tokens are only stored as a sha256 hash so a copy of the database doesn't contain working
+-- credentials. Existing tokens are hashed in place so nobody gets logged out.
+ALTER TABLE refresh_tokens
+    ADD COLUMN id UUID,
+    ADD COLUMN token_hash TEXT;
+UPDATE refresh_tokens SET id = gen_random_uuid(), token_hash = encode(sha256(token::bytea), 'hex');
+ALTER TABLE refresh_tokens
+    DROP COLUMN token,
+    ALTER COLUMN id SET NOT NULL,
+    ALTER COLUMN token_hash SET NOT NULL,
+    ADD PRIMARY KEY (id),
+    ADD CONSTRAINT refresh_tokens_token_hash_key UNIQUE (token_hash);
+
+-- +goose Down
+-- The original tokens can't be recovered from their hashes, so everyone has to log in again
+DELETE FROM refresh_tokens;
+ALTER TABLE refresh_tokens
+    DROP COLUMN id,
+    DROP COLUMN token_hash,
+    ADD COLUMN token TEXT PRIMARY KEY;
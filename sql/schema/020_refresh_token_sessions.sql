@@ -0,0 +1,20 @@
+-- +goose Up
+-- A session is a family of refresh tokens. These are captured at login and carried over to every token
+-- the family is rotated into.
+ALTER TABLE refresh_tokens
+    ADD COLUMN session_created_at TIMESTAMP,
+    ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+UPDATE refresh_tokens r
+SET session_created_at = f.started_at
+FROM (SELECT family_id, MIN(created_at) AS started_at FROM refresh_tokens GROUP BY family_id) f
+WHERE r.family_id = f.family_id;
+ALTER TABLE refresh_tokens ALTER COLUMN session_created_at SET NOT NULL;
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_user_id_idx;
+ALTER TABLE refresh_tokens
+    DROP COLUMN session_created_at,
+    DROP COLUMN user_agent,
+    DROP COLUMN ip;
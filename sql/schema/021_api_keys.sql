@@ -0,0 +1,18 @@
+-- +goose Up
+-- Long lived credentials for bots. Like refresh tokens only the sha256 hash of a key is stored, the prefix
+-- is kept so users can tell their keys apart.
+CREATE TABLE api_keys (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    name TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    key_prefix TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    last_used_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);
+
+-- +goose Down
+DROP TABLE api_keys;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- The secret is stored as soon as enrollment starts but two-factor auth only turns on once a code from it
+-- has been confirmed. The last used step keeps a code from being accepted twice.
+ALTER TABLE users
+    ADD COLUMN totp_secret TEXT,
+    ADD COLUMN totp_enabled_at TIMESTAMP,
+    ADD COLUMN totp_last_used_step BIGINT;
+
+CREATE TABLE mfa_recovery_codes (
+    code_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    used_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX mfa_recovery_codes_user_id_idx ON mfa_recovery_codes (user_id);
+
+-- +goose Down
+DROP TABLE mfa_recovery_codes;
+ALTER TABLE users
+    DROP COLUMN totp_secret,
+    DROP COLUMN totp_enabled_at,
+    DROP COLUMN totp_last_used_step;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- Failed logins per account (keyed by the email that was tried, so emails without an account are throttled
+-- the same way) and per ip address
+CREATE TABLE login_throttles (
+    key TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE login_throttles;
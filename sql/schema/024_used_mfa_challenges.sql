@@ -0,0 +1,13 @@
+-- +goose Up
+-- Mfa tokens that have already been exchanged for a session, so each one only works once. They're kept
+-- until they expire, after that the token is rejected anyway.
+CREATE TABLE used_mfa_challenges (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX used_mfa_challenges_expires_at_idx ON used_mfa_challenges (expires_at);
+
+-- +goose Down
+DROP TABLE used_mfa_challenges;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- Set when a token is revoked because it was exchanged for a new one, as opposed to logging out or
+-- revoking the session. Only a rotated token showing up again means it was likely stolen.
+ALTER TABLE refresh_tokens ADD COLUMN rotated_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN rotated_at;
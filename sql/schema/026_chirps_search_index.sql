@@ -0,0 +1,11 @@
+-- +goose Up
+-- Searching through an expression index instead of a stored column keeps the tsvector out of every query that
+-- reads whole chirps. Searches have to use exactly this expression for the index to be used.
+DROP INDEX chirps_body_tsv_idx;
+ALTER TABLE chirps DROP COLUMN body_tsv;
+CREATE INDEX chirps_body_tsv_idx ON chirps USING GIN (to_tsvector('english', body));
+
+-- +goose Down
+DROP INDEX chirps_body_tsv_idx;
+ALTER TABLE chirps ADD COLUMN body_tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', body)) STORED;
+CREATE INDEX chirps_body_tsv_idx ON chirps USING GIN (body_tsv);